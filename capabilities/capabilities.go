@@ -0,0 +1,144 @@
+package capabilities
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type Capability uint
+
+const (
+	CAP_DAC_READ_SEARCH Capability = 2
+	CAP_NET_ADMIN       Capability = 12
+	CAP_NET_RAW         Capability = 13
+	CAP_SYS_PTRACE      Capability = 19
+	CAP_SYS_ADMIN       Capability = 21
+	CAP_SYS_RESOURCE    Capability = 24
+	CAP_PERFMON         Capability = 38
+	CAP_BPF             Capability = 39
+)
+
+func (c Capability) String() string {
+	switch c {
+	case CAP_DAC_READ_SEARCH:
+		return "CAP_DAC_READ_SEARCH"
+	case CAP_NET_ADMIN:
+		return "CAP_NET_ADMIN"
+	case CAP_NET_RAW:
+		return "CAP_NET_RAW"
+	case CAP_SYS_PTRACE:
+		return "CAP_SYS_PTRACE"
+	case CAP_SYS_ADMIN:
+		return "CAP_SYS_ADMIN"
+	case CAP_SYS_RESOURCE:
+		return "CAP_SYS_RESOURCE"
+	case CAP_PERFMON:
+		return "CAP_PERFMON"
+	case CAP_BPF:
+		return "CAP_BPF"
+	}
+	return "CAP_" + strconv.Itoa(int(c))
+}
+
+type Feature string
+
+const (
+	FeatureEbpf        Feature = "ebpf"
+	FeatureUprobes     Feature = "uprobes"
+	FeatureNetNsSwitch Feature = "netns_switch"
+	FeatureUtsNs       Feature = "uts_ns"
+	FeatureTaskstats   Feature = "taskstats"
+	FeatureConntrack   Feature = "conntrack"
+	FeaturePinger      Feature = "pinger"
+)
+
+// requirements lists the alternative capability sets for each feature, any of which is sufficient.
+var requirements = map[Feature][][]Capability{
+	FeatureEbpf:        {{CAP_BPF, CAP_PERFMON}, {CAP_SYS_ADMIN}},
+	FeatureUprobes:     {{CAP_PERFMON, CAP_SYS_PTRACE}, {CAP_SYS_ADMIN, CAP_SYS_PTRACE}},
+	FeatureNetNsSwitch: {{CAP_SYS_ADMIN}},
+	FeatureUtsNs:       {{CAP_SYS_ADMIN}},
+	FeatureTaskstats:   {{CAP_NET_ADMIN}},
+	FeatureConntrack:   {{CAP_NET_ADMIN}},
+	FeaturePinger:      {{CAP_NET_RAW, CAP_SYS_ADMIN}},
+}
+
+var (
+	lock      sync.RWMutex
+	effective *uint64
+)
+
+// Init reads the effective capabilities of the agent. Until it succeeds, all the features are considered enabled.
+func Init() error {
+	data, err := os.ReadFile("/proc/self/status")
+	if err != nil {
+		return err
+	}
+	caps, err := parseEffective(data)
+	if err != nil {
+		return err
+	}
+	lock.Lock()
+	effective = &caps
+	lock.Unlock()
+	return nil
+}
+
+func parseEffective(status []byte) (uint64, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(status))
+	for scanner.Scan() {
+		name, value, ok := strings.Cut(scanner.Text(), ":")
+		if !ok || name != "CapEff" {
+			continue
+		}
+		return strconv.ParseUint(strings.TrimSpace(value), 16, 64)
+	}
+	return 0, fmt.Errorf("no CapEff in the process status")
+}
+
+// Check returns an error listing the missing capabilities if the feature is unavailable.
+func Check(f Feature) error {
+	lock.RLock()
+	defer lock.RUnlock()
+	if effective == nil {
+		return nil
+	}
+	var alternatives []string
+	for _, caps := range requirements[f] {
+		var missing []string
+		for _, c := range caps {
+			if *effective&(1<<c) == 0 {
+				missing = append(missing, c.String())
+			}
+		}
+		if len(missing) == 0 {
+			return nil
+		}
+		alternatives = append(alternatives, strings.Join(missing, "+"))
+	}
+	if len(alternatives) == 0 {
+		return nil
+	}
+	return fmt.Errorf("missing %s", strings.Join(alternatives, " or "))
+}
+
+func Enabled(f Feature) bool {
+	return Check(f) == nil
+}
+
+func Features() []Feature {
+	res := make([]Feature, 0, len(requirements))
+	for f := range requirements {
+		res = append(res, f)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i] < res[j]
+	})
+	return res
+}
@@ -0,0 +1,34 @@
+package capabilities
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheck(t *testing.T) {
+	saved := effective
+	t.Cleanup(func() {
+		effective = saved
+	})
+
+	assert.NoError(t, Check(FeatureEbpf))
+
+	caps, err := parseEffective([]byte("Name:\tcoroot-node-agent\nCapInh:\t0000000000000000\nCapEff:\t000000c001081000\n"))
+	require.NoError(t, err)
+	effective = &caps
+
+	assert.NoError(t, Check(FeatureEbpf))
+	assert.NoError(t, Check(FeatureUprobes))
+	assert.NoError(t, Check(FeatureTaskstats))
+	assert.NoError(t, Check(FeatureConntrack))
+	assert.EqualError(t, Check(FeatureNetNsSwitch), "missing CAP_SYS_ADMIN")
+	assert.EqualError(t, Check(FeaturePinger), "missing CAP_NET_RAW+CAP_SYS_ADMIN")
+
+	caps = 0
+	assert.EqualError(t, Check(FeatureEbpf), "missing CAP_BPF+CAP_PERFMON or CAP_SYS_ADMIN")
+
+	_, err = parseEffective([]byte("Name:\tcoroot-node-agent\n"))
+	assert.Error(t, err)
+}
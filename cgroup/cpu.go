@@ -10,8 +10,17 @@ import (
 
 type CPUStat struct {
 	UsageSeconds         float64
+	UserSeconds          float64
+	SystemSeconds        float64
+	HasUserSystem        bool // false on v1 if cpuacct.usage_user and cpuacct.usage_sys are not available
 	ThrottledTimeSeconds float64
+	Periods              uint64
+	ThrottledPeriods     uint64
 	LimitCores           float64
+	Shares               uint64 // cpu.shares, v1 only
+	Weight               uint64 // cpu.weight, v2 only
+	CpusetCpus           string
+	CpusetCount          int
 }
 
 func (cg Cgroup) CpuStat() (*CPUStat, error) {
@@ -41,10 +50,23 @@ func (cg Cgroup) cpuStatV1() (*CPUStat, error) {
 	res := &CPUStat{
 		UsageSeconds:         float64(usageNs) / 1e9,
 		ThrottledTimeSeconds: float64(throttling["throttled_time"]) / 1e9,
+		Periods:              throttling["nr_periods"],
+		ThrottledPeriods:     throttling["nr_throttled"],
 	}
 	if quotaUs > 0 {
 		res.LimitCores = float64(quotaUs) / float64(periodUs)
 	}
+	// cpuacct.usage_user and cpuacct.usage_sys are available since Linux 4.7
+	userNs, userErr := readUintFromFile(path.Join(cgRoot, "cpuacct", cg.subsystems["cpuacct"], "cpuacct.usage_user"))
+	systemNs, systemErr := readUintFromFile(path.Join(cgRoot, "cpuacct", cg.subsystems["cpuacct"], "cpuacct.usage_sys"))
+	if userErr == nil && systemErr == nil {
+		res.UserSeconds = float64(userNs) / 1e9
+		res.SystemSeconds = float64(systemNs) / 1e9
+		res.HasUserSystem = true
+	}
+	res.Shares, _ = readUintFromFile(path.Join(cgRoot, "cpu", cg.subsystems["cpu"], "cpu.shares"))
+	dir := path.Join(cgRoot, "cpuset", cg.subsystems["cpuset"])
+	res.CpusetCpus, res.CpusetCount = readCpuset(path.Join(dir, "cpuset.effective_cpus"), path.Join(dir, "cpuset.cpus"))
 	return res, nil
 }
 
@@ -55,8 +77,16 @@ func (cg Cgroup) cpuStatV2() (*CPUStat, error) {
 	}
 	res := &CPUStat{
 		UsageSeconds:         float64(vars["usage_usec"]) / 1e6,
+		UserSeconds:          float64(vars["user_usec"]) / 1e6,
+		SystemSeconds:        float64(vars["system_usec"]) / 1e6,
+		HasUserSystem:        true,
 		ThrottledTimeSeconds: float64(vars["throttled_usec"]) / 1e6,
+		Periods:              vars["nr_periods"],
+		ThrottledPeriods:     vars["nr_throttled"],
 	}
+	res.Weight, _ = readUintFromFile(path.Join(cgRoot, cg.subsystems[""], "cpu.weight"))
+	dir := path.Join(cgRoot, cg.subsystems[""])
+	res.CpusetCpus, res.CpusetCount = readCpuset(path.Join(dir, "cpuset.cpus.effective"))
 	payload, err := ioutil.ReadFile(path.Join(cgRoot, cg.subsystems[""], "cpu.max"))
 	if err != nil {
 		return nil, err
@@ -82,3 +112,43 @@ func (cg Cgroup) cpuStatV2() (*CPUStat, error) {
 	}
 	return res, nil
 }
+
+// readCpuset returns the CPU list from the first readable non-empty file and the number of CPUs in it.
+func readCpuset(filePaths ...string) (string, int) {
+	for _, p := range filePaths {
+		data, err := ioutil.ReadFile(p)
+		if err != nil {
+			continue
+		}
+		cpus := strings.TrimSpace(string(data))
+		if cpus == "" {
+			continue
+		}
+		count, err := parseCpuList(cpus)
+		if err != nil {
+			return "", 0
+		}
+		return cpus, count
+	}
+	return "", 0
+}
+
+// parseCpuList counts the CPUs in a list like "0-3,8,10-11".
+func parseCpuList(list string) (int, error) {
+	count := 0
+	for _, r := range strings.Split(list, ",") {
+		from, to, isRange := strings.Cut(r, "-")
+		start, err := strconv.Atoi(from)
+		if err != nil {
+			return 0, fmt.Errorf("invalid cpu list %s", list)
+		}
+		end := start
+		if isRange {
+			if end, err = strconv.Atoi(to); err != nil || end < start {
+				return 0, fmt.Errorf("invalid cpu list %s", list)
+			}
+		}
+		count += end - start + 1
+	}
+	return count, nil
+}
@@ -15,12 +15,21 @@ func TestCgroup_CpuStat(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, 0., s.LimitCores)
 	assert.Equal(t, 26778.913419246, s.UsageSeconds)
+	assert.False(t, s.HasUserSystem)
 
 	cg, _ = NewFromProcessCgroupFile(path.Join("fixtures/proc/200/cgroup"))
 	s, err = cg.CpuStat()
 	assert.Nil(t, err)
 	assert.Equal(t, 1.5, s.LimitCores)
 	assert.Equal(t, 254005.032764376, s.ThrottledTimeSeconds)
+	assert.Equal(t, uint64(380975143), s.Periods)
+	assert.Equal(t, uint64(5583849), s.ThrottledPeriods)
+	assert.Equal(t, 90000.5, s.UserSeconds)
+	assert.Equal(t, 17292., s.SystemSeconds)
+	assert.True(t, s.HasUserSystem)
+	assert.Equal(t, uint64(1024), s.Shares)
+	assert.Equal(t, "0-3,8,10-11", s.CpusetCpus)
+	assert.Equal(t, 7, s.CpusetCount)
 
 	cg, _ = NewFromProcessCgroupFile(path.Join("fixtures/proc/400/cgroup"))
 	s, err = cg.CpuStat()
@@ -28,6 +37,12 @@ func TestCgroup_CpuStat(t *testing.T) {
 	assert.Equal(t, 0.1, s.LimitCores)
 	assert.Equal(t, 0.363166, s.ThrottledTimeSeconds)
 	assert.Equal(t, 3795.681254, s.UsageSeconds)
+	assert.Equal(t, 3160.014031, s.UserSeconds)
+	assert.Equal(t, 635.667223, s.SystemSeconds)
+	assert.True(t, s.HasUserSystem)
+	assert.Equal(t, uint64(809036), s.Periods)
+	assert.Equal(t, uint64(76), s.ThrottledPeriods)
+	assert.Equal(t, "", s.CpusetCpus)
 
 	cg, _ = NewFromProcessCgroupFile(path.Join("fixtures/proc/500/cgroup"))
 	s, err = cg.CpuStat()
@@ -35,4 +50,22 @@ func TestCgroup_CpuStat(t *testing.T) {
 	assert.Equal(t, 0., s.LimitCores)
 	assert.Equal(t, 0., s.ThrottledTimeSeconds)
 	assert.Equal(t, 5531.521992, s.UsageSeconds)
+	assert.Equal(t, uint64(100), s.Weight)
+	assert.Equal(t, "0-7", s.CpusetCpus)
+	assert.Equal(t, 8, s.CpusetCount)
+}
+
+func TestParseCpuList(t *testing.T) {
+	n, err := parseCpuList("0")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+
+	n, err = parseCpuList("0-3,8,10-11")
+	assert.Nil(t, err)
+	assert.Equal(t, 7, n)
+
+	_, err = parseCpuList("3-1")
+	assert.Error(t, err)
+	_, err = parseCpuList("a")
+	assert.Error(t, err)
 }
@@ -7,9 +7,27 @@ import (
 const maxMemory = 1 << 62
 
 type MemoryStat struct {
-	RSS   uint64
-	Cache uint64
-	Limit uint64
+	RSS          uint64
+	Cache        uint64
+	Limit        uint64
+	High         uint64
+	Swap         uint64
+	SwapLimit    uint64
+	ActiveFile   uint64
+	InactiveFile uint64
+	// WorkingSet is calculated the same way as Kubernetes does: usage - inactive_file.
+	WorkingSet      uint64
+	PageFaults      uint64
+	MajorPageFaults uint64
+}
+
+// MemoryEvents are the counters of memory.events (v2).
+// On cgroup v1, Max is taken from memory.failcnt and OOMKill from memory.oom_control, High and OOM are not available.
+type MemoryEvents struct {
+	High    uint64
+	Max     uint64
+	OOM     uint64
+	OOMKill uint64
 }
 
 func (cg *Cgroup) MemoryStat() (*MemoryStat, error) {
@@ -19,12 +37,20 @@ func (cg *Cgroup) MemoryStat() (*MemoryStat, error) {
 	return cg.memoryStatV2()
 }
 
+func (cg *Cgroup) MemoryEvents() (*MemoryEvents, error) {
+	if cg.Version == V1 {
+		return cg.memoryEventsV1()
+	}
+	return cg.memoryEventsV2()
+}
+
 func (cg *Cgroup) memoryStatV1() (*MemoryStat, error) {
-	vars, err := readVariablesFromFile(path.Join(cgRoot, "memory", cg.subsystems["memory"], "memory.stat"))
+	dir := path.Join(cgRoot, "memory", cg.subsystems["memory"])
+	vars, err := readVariablesFromFile(path.Join(dir, "memory.stat"))
 	if err != nil {
 		return nil, err
 	}
-	limit, err := readUintFromFile(path.Join(cgRoot, "memory", cg.subsystems["memory"], "memory.limit_in_bytes"))
+	limit, err := readUintFromFile(path.Join(dir, "memory.limit_in_bytes"))
 	if err != nil {
 		return nil, err
 	}
@@ -39,22 +65,86 @@ func (cg *Cgroup) memoryStatV1() (*MemoryStat, error) {
 	//	(Note: file and shmem may be shared among other cgroups. In that case,
 	//	 mapped_file is accounted only when the memory cgroup is owner of page
 	//	 cache.)
-	return &MemoryStat{
-		RSS:   vars["rss"] + vars["mapped_file"],
-		Cache: vars["cache"],
-		Limit: limit,
-	}, nil
+	res := &MemoryStat{
+		RSS:             vars["rss"] + vars["mapped_file"],
+		Cache:           vars["cache"],
+		Limit:           limit,
+		Swap:            vars["swap"],
+		ActiveFile:      vars["active_file"],
+		InactiveFile:    vars["inactive_file"],
+		PageFaults:      vars["pgfault"],
+		MajorPageFaults: vars["pgmajfault"],
+	}
+	// memory.memsw.* files exist only if swap accounting is enabled
+	if memsw, err := readUintFromFile(path.Join(dir, "memory.memsw.limit_in_bytes")); err == nil && limit > 0 && memsw < maxMemory && memsw > limit {
+		res.SwapLimit = memsw - limit
+	}
+	// memory.usage_in_bytes is hierarchical, so the inactive file cache of the descendants is subtracted as well
+	if usage, err := readUintFromFile(path.Join(dir, "memory.usage_in_bytes")); err == nil {
+		res.WorkingSet = workingSet(usage, vars["total_inactive_file"])
+	}
+	return res, nil
 }
 
 func (cg *Cgroup) memoryStatV2() (*MemoryStat, error) {
-	vars, err := readVariablesFromFile(path.Join(cgRoot, cg.subsystems[""], "memory.stat"))
+	dir := path.Join(cgRoot, cg.subsystems[""])
+	vars, err := readVariablesFromFile(path.Join(dir, "memory.stat"))
+	if err != nil {
+		return nil, err
+	}
+	// memory.max, memory.high and memory.swap.max contain "max" if there is no limit
+	limit, _ := readUintFromFile(path.Join(dir, "memory.max"))
+	high, _ := readUintFromFile(path.Join(dir, "memory.high"))
+	swapLimit, _ := readUintFromFile(path.Join(dir, "memory.swap.max"))
+	swap, _ := readUintFromFile(path.Join(dir, "memory.swap.current"))
+	res := &MemoryStat{
+		RSS:             vars["anon"] + vars["file_mapped"],
+		Cache:           vars["file"],
+		Limit:           limit,
+		High:            high,
+		Swap:            swap,
+		SwapLimit:       swapLimit,
+		ActiveFile:      vars["active_file"],
+		InactiveFile:    vars["inactive_file"],
+		PageFaults:      vars["pgfault"],
+		MajorPageFaults: vars["pgmajfault"],
+	}
+	if usage, err := readUintFromFile(path.Join(dir, "memory.current")); err == nil {
+		res.WorkingSet = workingSet(usage, res.InactiveFile)
+	}
+	return res, nil
+}
+
+func (cg *Cgroup) memoryEventsV1() (*MemoryEvents, error) {
+	dir := path.Join(cgRoot, "memory", cg.subsystems["memory"])
+	failcnt, err := readUintFromFile(path.Join(dir, "memory.failcnt"))
 	if err != nil {
 		return nil, err
 	}
-	limit, _ := readUintFromFile(path.Join(cgRoot, cg.subsystems[""], "memory.max"))
-	return &MemoryStat{
-		RSS:   vars["anon"] + vars["file_mapped"],
-		Cache: vars["file"],
-		Limit: limit,
+	res := &MemoryEvents{Max: failcnt}
+	// the oom_kill counter is available since Linux 4.13
+	if vars, err := readVariablesFromFile(path.Join(dir, "memory.oom_control")); err == nil {
+		res.OOMKill = vars["oom_kill"]
+	}
+	return res, nil
+}
+
+func (cg *Cgroup) memoryEventsV2() (*MemoryEvents, error) {
+	vars, err := readVariablesFromFile(path.Join(cgRoot, cg.subsystems[""], "memory.events"))
+	if err != nil {
+		return nil, err
+	}
+	return &MemoryEvents{
+		High:    vars["high"],
+		Max:     vars["max"],
+		OOM:     vars["oom"],
+		OOMKill: vars["oom_kill"],
 	}, nil
 }
+
+func workingSet(usage, inactiveFile uint64) uint64 {
+	if usage < inactiveFile {
+		return 0
+	}
+	return usage - inactiveFile
+}
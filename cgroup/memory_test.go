@@ -21,6 +21,13 @@ func TestCgroup_MemoryStat(t *testing.T) {
 	assert.Equal(t, uint64(14775123968), stat.RSS)
 	assert.Equal(t, uint64(3206844416), stat.Cache)
 	assert.Equal(t, uint64(21474836480), stat.Limit)
+	assert.Equal(t, uint64(104857600), stat.Swap)
+	assert.Equal(t, uint64(25769803776-21474836480), stat.SwapLimit)
+	assert.Equal(t, uint64(1604784128), stat.ActiveFile)
+	assert.Equal(t, uint64(1601998848), stat.InactiveFile)
+	assert.Equal(t, uint64(16106127360-1702662144), stat.WorkingSet)
+	assert.Equal(t, uint64(4304414697), stat.PageFaults)
+	assert.Equal(t, uint64(44816508), stat.MajorPageFaults)
 
 	cg, _ = NewFromProcessCgroupFile(path.Join("fixtures/proc/400/cgroup"))
 	stat, err = cg.MemoryStat()
@@ -28,6 +35,8 @@ func TestCgroup_MemoryStat(t *testing.T) {
 	assert.Equal(t, uint64(44892160+0), stat.RSS)
 	assert.Equal(t, uint64(1044480), stat.Cache)
 	assert.Equal(t, uint64(0), stat.Limit)
+	assert.Equal(t, uint64(0), stat.High)
+	assert.Equal(t, uint64(48648192-159744), stat.WorkingSet)
 
 	cg, _ = NewFromProcessCgroupFile(path.Join("fixtures/proc/500/cgroup"))
 	stat, err = cg.MemoryStat()
@@ -35,5 +44,30 @@ func TestCgroup_MemoryStat(t *testing.T) {
 	assert.Equal(t, uint64(75247616+4038656), stat.RSS)
 	assert.Equal(t, uint64(50835456), stat.Cache)
 	assert.Equal(t, uint64(4294967296), stat.Limit)
+	assert.Equal(t, uint64(3221225472), stat.High)
+	assert.Equal(t, uint64(1048576), stat.Swap)
+	assert.Equal(t, uint64(0), stat.SwapLimit)
+	assert.Equal(t, uint64(630784), stat.ActiveFile)
+	assert.Equal(t, uint64(50204672), stat.InactiveFile)
+	assert.Equal(t, uint64(131047424-50204672), stat.WorkingSet)
+	assert.Equal(t, uint64(9245661), stat.PageFaults)
+	assert.Equal(t, uint64(1), stat.MajorPageFaults)
+}
+
+func TestCgroup_MemoryEvents(t *testing.T) {
+	cgRoot = "fixtures/cgroup"
+
+	cg, _ := NewFromProcessCgroupFile(path.Join("fixtures/proc/200/cgroup"))
+	events, err := cg.MemoryEvents()
+	assert.Nil(t, err)
+	assert.Equal(t, MemoryEvents{Max: 42, OOMKill: 3}, *events)
 
+	cg, _ = NewFromProcessCgroupFile(path.Join("fixtures/proc/400/cgroup"))
+	_, err = cg.MemoryEvents()
+	assert.Error(t, err)
+
+	cg, _ = NewFromProcessCgroupFile(path.Join("fixtures/proc/500/cgroup"))
+	events, err = cg.MemoryEvents()
+	assert.Nil(t, err)
+	assert.Equal(t, MemoryEvents{High: 118, Max: 7, OOM: 2, OOMKill: 1}, *events)
 }
@@ -0,0 +1,83 @@
+package cgroup
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strconv"
+	"strings"
+)
+
+type PSI struct {
+	SomeSeconds float64
+	FullSeconds float64
+	HasFull     bool // the `full` line is missing for CPU before kernel 5.13
+}
+
+// PSIStat holds the pressure of each resource, nil if its pressure file is unavailable.
+type PSIStat struct {
+	CPU    *PSI
+	Memory *PSI
+	IO     *PSI
+}
+
+// PSI returns the pressure stall information of the cgroup. It is available only on cgroup v2 (kernel 4.20+, CONFIG_PSI).
+func (cg Cgroup) PSI() (*PSIStat, error) {
+	if cg.Version == V1 {
+		return nil, fmt.Errorf("PSI is not supported for cgroup v1")
+	}
+	res := &PSIStat{}
+	var lastErr error
+	for file, psi := range map[string]**PSI{"cpu.pressure": &res.CPU, "memory.pressure": &res.Memory, "io.pressure": &res.IO} {
+		p, err := readPSIFromFile(path.Join(cgRoot, cg.subsystems[""], file))
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		*psi = p
+	}
+	if res.CPU == nil && res.Memory == nil && res.IO == nil {
+		return nil, lastErr
+	}
+	return res, nil
+}
+
+// readPSIFromFile parses the totals (in microseconds) of a pressure file:
+//
+//	some avg10=0.00 avg60=0.00 avg300=0.00 total=12345
+//	full avg10=0.00 avg60=0.00 avg300=0.00 total=6789
+func readPSIFromFile(filePath string) (*PSI, error) {
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	psi := &PSI{}
+	for _, line := range strings.Split(string(data), "\n") {
+		parts := strings.Fields(line)
+		if len(parts) == 0 {
+			continue
+		}
+		var dst *float64
+		switch parts[0] {
+		case "some":
+			dst = &psi.SomeSeconds
+		case "full":
+			dst = &psi.FullSeconds
+			psi.HasFull = true
+		default:
+			continue
+		}
+		for _, p := range parts[1:] {
+			v, ok := strings.CutPrefix(p, "total=")
+			if !ok {
+				continue
+			}
+			totalUs, err := strconv.ParseUint(v, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid total value in %s: %s", filePath, v)
+			}
+			*dst = float64(totalUs) / 1e6
+		}
+	}
+	return psi, nil
+}
@@ -0,0 +1,27 @@
+package cgroup
+
+import (
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCgroup_PSI(t *testing.T) {
+	cgRoot = "fixtures/cgroup"
+
+	cg, _ := NewFromProcessCgroupFile(path.Join("fixtures/proc/100/cgroup"))
+	_, err := cg.PSI()
+	assert.Error(t, err)
+
+	cg, _ = NewFromProcessCgroupFile(path.Join("fixtures/proc/400/cgroup"))
+	_, err = cg.PSI()
+	assert.Error(t, err) // no pressure files
+
+	cg, _ = NewFromProcessCgroupFile(path.Join("fixtures/proc/500/cgroup"))
+	stat, err := cg.PSI()
+	assert.Nil(t, err)
+	assert.Equal(t, &PSI{SomeSeconds: 5.841203}, stat.CPU) // no `full` line
+	assert.Equal(t, &PSI{SomeSeconds: 1.234567, FullSeconds: 1, HasFull: true}, stat.Memory)
+	assert.Nil(t, stat.IO) // no io.pressure
+}
@@ -6,7 +6,7 @@ import (
 
 func AuthHeaders() map[string]string {
 	res := map[string]string{}
-	if apiKey := *flags.ApiKey; apiKey != "" {
+	if apiKey := flags.Get(flags.ApiKey); apiKey != "" {
 		res["X-Api-Key"] = apiKey
 	}
 	return res
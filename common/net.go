@@ -1,8 +1,10 @@
 package common
 
 import (
+	"fmt"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/coroot/coroot-node-agent/flags"
 	"inet.af/netaddr"
@@ -13,43 +15,53 @@ var (
 	ConnectionFilter = connectionFilter{
 		whitelist: map[string]netaddr.IPPrefix{},
 	}
-	PortFilter *portFilter
+	PortFilter = &portFilter{}
 )
 
 func init() {
-	klog.Infoln("whitelisted public IPs:", *flags.ExternalNetworksWhitelist)
-	for _, prefix := range *flags.ExternalNetworksWhitelist {
-		if prefix == "" {
-			continue
-		}
-		p, err := netaddr.ParseIPPrefix(prefix)
-		if err != nil {
-			klog.Fatalf("invalid network %s: %s", prefix, err)
-		}
-		ConnectionFilter.WhitelistPrefix(p)
+	networks, err := parseNetworks(flags.Get(flags.ExternalNetworksWhitelist))
+	if err != nil {
+		klog.Fatalln(err)
 	}
-	if r := flags.EphemeralPortRange; r != nil && *r != "" {
-		klog.Infoln("ephemeral-port-range:", *r)
-		parts := strings.Split(*r, "-")
-		if len(parts) != 2 {
-			klog.Fatalf("invalid port range: %s", *r)
-		}
-		from, err := strconv.ParseUint(parts[0], 10, 16)
+	klog.Infoln("whitelisted public IPs:", flags.Get(flags.ExternalNetworksWhitelist))
+	ConnectionFilter.SetNetworks(networks)
+
+	if err = PortFilter.Set(flags.Get(flags.EphemeralPortRange)); err != nil {
+		klog.Fatalln(err)
+	}
+	klog.Infoln("ephemeral-port-range:", flags.Get(flags.EphemeralPortRange))
+
+	flags.OnReload(func() {
+		networks, err := parseNetworks(flags.Get(flags.ExternalNetworksWhitelist))
 		if err != nil {
-			klog.Fatalf("invalid port range: %s", *r)
+			klog.Errorln(err)
+			return
 		}
-		to, err := strconv.ParseUint(parts[1], 10, 16)
-		if err != nil {
-			klog.Fatalf("invalid port range: %s", *r)
+		klog.Infoln("whitelisted public IPs:", flags.Get(flags.ExternalNetworksWhitelist))
+		ConnectionFilter.SetNetworks(networks)
+	}, "track-public-network")
+	flags.OnReload(func() {
+		if err := PortFilter.Set(flags.Get(flags.EphemeralPortRange)); err != nil {
+			klog.Errorln(err)
+			return
 		}
-		if from > to {
-			klog.Fatalf("invalid port range: %s", *r)
+		klog.Infoln("ephemeral-port-range:", flags.Get(flags.EphemeralPortRange))
+	}, "ephemeral-port-range")
+}
+
+func parseNetworks(networks []string) ([]netaddr.IPPrefix, error) {
+	var res []netaddr.IPPrefix
+	for _, prefix := range networks {
+		if prefix == "" {
+			continue
 		}
-		PortFilter = &portFilter{
-			from: uint16(from),
-			to:   uint16(to),
+		p, err := netaddr.ParseIPPrefix(prefix)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network %s: %w", prefix, err)
 		}
+		res = append(res, p)
 	}
+	return res, nil
 }
 
 func IsIpPrivate(ip netaddr.IP) bool {
@@ -63,11 +75,22 @@ func IsIpPrivate(ip netaddr.IP) bool {
 	return false
 }
 
+// connectionFilter allows connections to private networks, the configured networks
+// and the public IPs that are learned at runtime.
 type connectionFilter struct {
+	lock      sync.RWMutex
+	networks  []netaddr.IPPrefix
 	whitelist map[string]netaddr.IPPrefix
 }
 
-func (f connectionFilter) WhitelistIP(ip netaddr.IP) {
+// SetNetworks replaces the configured networks, the whitelisted IPs and prefixes are kept.
+func (f *connectionFilter) SetNetworks(networks []netaddr.IPPrefix) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.networks = networks
+}
+
+func (f *connectionFilter) WhitelistIP(ip netaddr.IP) {
 	var bits uint8 = 32
 	if ip.Is6() {
 		bits = 128
@@ -75,43 +98,84 @@ func (f connectionFilter) WhitelistIP(ip netaddr.IP) {
 	f.WhitelistPrefix(netaddr.IPPrefixFrom(ip, bits))
 }
 
-func (f connectionFilter) WhitelistPrefix(p netaddr.IPPrefix) {
+func (f *connectionFilter) WhitelistPrefix(p netaddr.IPPrefix) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
 	if _, ok := f.whitelist[p.String()]; ok {
 		return
 	}
 	f.whitelist[p.String()] = p
 }
 
-func (f connectionFilter) ShouldBeSkipped(dst, actualDst netaddr.IP) bool {
+func (f *connectionFilter) ShouldBeSkipped(dst, actualDst netaddr.IP) bool {
 	if IsIpPrivate(dst) || dst.IsLoopback() {
 		return false
 	}
-	for _, prefix := range f.whitelist {
-		if prefix.Contains(dst) {
-			return false
-		}
+	if f.whitelisted(dst) {
+		return false
 	}
-	if IsIpPrivate(actualDst) || actualDst.IsLoopback() {
+	if IsIpPrivate(actualDst) || actualDst.IsLoopback() || f.whitelisted(actualDst) {
 		f.WhitelistIP(dst)
 		return false
 	}
+	return true
+}
+
+func (f *connectionFilter) whitelisted(ip netaddr.IP) bool {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	for _, prefix := range f.networks {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
 	for _, prefix := range f.whitelist {
-		if prefix.Contains(actualDst) {
-			f.WhitelistIP(dst)
-			return false
+		if prefix.Contains(ip) {
+			return true
 		}
 	}
-	return true
+	return false
 }
 
 type portFilter struct {
-	from uint16
-	to   uint16
+	lock    sync.RWMutex
+	enabled bool
+	from    uint16
+	to      uint16
+}
+
+// Set parses a port range like 32768-60999, an empty string disables the filter.
+func (f *portFilter) Set(r string) error {
+	var from, to uint64
+	if r != "" {
+		parts := strings.Split(r, "-")
+		if len(parts) != 2 {
+			return fmt.Errorf("invalid port range: %s", r)
+		}
+		var err error
+		if from, err = strconv.ParseUint(parts[0], 10, 16); err != nil {
+			return fmt.Errorf("invalid port range: %s", r)
+		}
+		if to, err = strconv.ParseUint(parts[1], 10, 16); err != nil {
+			return fmt.Errorf("invalid port range: %s", r)
+		}
+		if from > to {
+			return fmt.Errorf("invalid port range: %s", r)
+		}
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.enabled = r != ""
+	f.from = uint16(from)
+	f.to = uint16(to)
+	return nil
 }
 
 func (f *portFilter) ShouldBeSkipped(port uint16) bool {
 	if f == nil {
 		return false
 	}
-	return port >= f.from && port <= f.to
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	return f.enabled && port >= f.from && port <= f.to
 }
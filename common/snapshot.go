@@ -0,0 +1,61 @@
+package common
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// The node collector and the containers read the same /proc and /sys files while collecting metrics.
+// Each Gather opens its own snapshot scope, in which the results are cached and shared by all the collectors,
+// so the number of reads doesn't grow with the number of containers.
+// An overlapping Gather opens a new scope instead of extending the current one, so the cached data is never older
+// than the start of the latest Gather and is dropped once it's done. Outside of scopes nothing is cached.
+type SnapshotScope struct {
+	lock    sync.Mutex
+	entries map[string]*snapshotEntry
+}
+
+type snapshotEntry struct {
+	once  sync.Once
+	value any
+	err   error
+}
+
+var currentSnapshot atomic.Pointer[SnapshotScope]
+
+// BeginSnapshot opens the scope the collectors read through until it is ended.
+func BeginSnapshot() *SnapshotScope {
+	s := &SnapshotScope{entries: map[string]*snapshotEntry{}}
+	currentSnapshot.Store(s)
+	return s
+}
+
+// End closes the scope, unless it has already been replaced by the scope of a later Gather.
+func (s *SnapshotScope) End() {
+	currentSnapshot.CompareAndSwap(s, nil)
+}
+
+func (s *SnapshotScope) entry(key string) *snapshotEntry {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	e := s.entries[key]
+	if e == nil {
+		e = &snapshotEntry{}
+		s.entries[key] = e
+	}
+	return e
+}
+
+// Snapshot returns the result of read cached within the current scope.
+func Snapshot[T any](key string, read func() (T, error)) (T, error) {
+	s := currentSnapshot.Load()
+	if s == nil {
+		return read()
+	}
+	e := s.entry(key)
+	e.once.Do(func() {
+		e.value, e.err = read()
+	})
+	v, _ := e.value.(T)
+	return v, e.err
+}
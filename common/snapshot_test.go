@@ -0,0 +1,38 @@
+package common
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSnapshot(t *testing.T) {
+	reads := 0
+	read := func() (int, error) {
+		reads++
+		return reads, nil
+	}
+
+	v, _ := Snapshot("key", read)
+	assert.Equal(t, 1, v)
+	v, _ = Snapshot("key", read)
+	assert.Equal(t, 2, v)
+
+	first := BeginSnapshot()
+	v, _ = Snapshot("key", read)
+	assert.Equal(t, 3, v)
+	v, _ = Snapshot("key", read)
+	assert.Equal(t, 3, v)
+
+	// an overlapping scope doesn't reuse the data of the previous one
+	second := BeginSnapshot()
+	v, _ = Snapshot("key", read)
+	assert.Equal(t, 4, v)
+	first.End()
+	v, _ = Snapshot("key", read)
+	assert.Equal(t, 4, v)
+	second.End()
+
+	v, _ = Snapshot("key", read)
+	assert.Equal(t, 5, v)
+}
@@ -3,6 +3,7 @@ package containers
 import (
 	"syscall"
 
+	"github.com/coroot/coroot-node-agent/capabilities"
 	"github.com/coroot/coroot-node-agent/common"
 	"github.com/florianl/go-conntrack"
 	"github.com/vishvananda/netns"
@@ -15,6 +16,9 @@ type Conntrack struct {
 }
 
 func NewConntrack(netNs netns.NsHandle) (*Conntrack, error) {
+	if err := capabilities.Check(capabilities.FeatureConntrack); err != nil {
+		return nil, err
+	}
 	c, err := conntrack.Open(&conntrack.Config{NetNS: int(netNs)})
 	if err != nil {
 		return nil, err
@@ -23,6 +27,9 @@ func NewConntrack(netNs netns.NsHandle) (*Conntrack, error) {
 }
 
 func (c *Conntrack) GetActualDestination(src, dst netaddr.IPPort) *netaddr.IPPort {
+	if c == nil {
+		return nil
+	}
 	tcp := uint8(syscall.IPPROTO_TCP)
 	sip := src.IP().IPAddr().IP
 	dip := dst.IP().IPAddr().IP
@@ -1,16 +1,18 @@
 package containers
 
 import (
+	"context"
 	"os"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/coroot/coroot-node-agent/capabilities"
 	"github.com/coroot/coroot-node-agent/cgroup"
 	"github.com/coroot/coroot-node-agent/common"
-	"github.com/coroot/coroot-node-agent/ebpftracer"
 	"github.com/coroot/coroot-node-agent/ebpftracer/l7"
 	"github.com/coroot/coroot-node-agent/flags"
+	"github.com/coroot/coroot-node-agent/governor"
 	"github.com/coroot/coroot-node-agent/logs"
 	"github.com/coroot/coroot-node-agent/node"
 	"github.com/coroot/coroot-node-agent/pinger"
@@ -37,6 +39,7 @@ type ContainerNetwork struct {
 type ContainerMetadata struct {
 	name               string
 	labels             map[string]string
+	annotations        map[string]string
 	volumes            map[string]string
 	logPath            string
 	image              string
@@ -52,9 +55,65 @@ type Delays struct {
 	disk time.Duration
 }
 
+const (
+	// the same as in logparser
+	logShedMultilineTimeout = 100 * time.Millisecond
+	logShedMultilineLimit   = 64 * 1024
+)
+
 type LogParser struct {
 	parser *logparser.Parser
 	stop   func()
+	cancel context.CancelFunc
+
+	shedLock sync.Mutex
+	shed     map[logparser.Level]int
+}
+
+// newLogParser starts a parser of the entries sent to the returned channel.
+// While the governor sheds log parsing, the patterns aren't extracted, and the messages are only counted by level.
+func newLogParser(decoder logparser.Decoder, containerId string) (*LogParser, chan<- logparser.LogEntry) {
+	in := make(chan logparser.LogEntry)
+	out := make(chan logparser.LogEntry)
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &LogParser{
+		parser: logparser.NewParser(out, decoder, logs.OtelLogEmitter(containerId)),
+		cancel: cancel,
+		shed:   map[logparser.Level]int{},
+	}
+	shed := logparser.NewMultilineCollector(ctx, logShedMultilineTimeout, logShedMultilineLimit)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case e := <-in:
+				if governor.ParseLogs() {
+					select {
+					case out <- e:
+					case <-ctx.Done():
+						return
+					}
+					continue
+				}
+				if decoder != nil {
+					var err error
+					if e.Content, err = decoder.Decode(e.Content); err != nil {
+						continue
+					}
+				}
+				shed.Add(e)
+			}
+		}
+	}()
+	go func() {
+		for msg := range shed.Messages {
+			p.shedLock.Lock()
+			p.shed[msg.Level]++
+			p.shedLock.Unlock()
+		}
+	}()
+	return p, in
 }
 
 func (p *LogParser) Stop() {
@@ -62,6 +121,19 @@ func (p *LogParser) Stop() {
 		p.stop()
 	}
 	p.parser.Stop()
+	if p.cancel != nil {
+		p.cancel()
+	}
+}
+
+func (p *LogParser) shedMessages() map[logparser.Level]int {
+	p.shedLock.Lock()
+	defer p.shedLock.Unlock()
+	res := make(map[logparser.Level]int, len(p.shed))
+	for level, n := range p.shed {
+		res[level] = n
+	}
+	return res
 }
 
 type AddrPair struct {
@@ -110,6 +182,8 @@ type Container struct {
 	listens map[netaddr.IPPort]map[uint32]*ListenDetails
 	ipsByNs map[string][]netaddr.IP
 
+	netNamespaces map[string]uint32 // non-host network namespace -> pid to read its /proc/<pid>/net from
+
 	connectsSuccessful map[AddrPair]int64           // dst:actual_dst -> count
 	connectsFailed     map[netaddr.IPPort]int64     // dst -> count
 	connectLastAttempt map[netaddr.IPPort]time.Time // dst -> time
@@ -117,20 +191,29 @@ type Container struct {
 	connectionsByPidFd map[PidFd]*ActiveConnection
 	retransmits        map[AddrPair]int64 // dst:actual_dst -> count
 
-	l7Stats  L7Stats
-	dnsStats *L7Metrics
+	l7Stats    L7Stats
+	l7Limit    *seriesLimit
+	dnsStats   *L7Metrics
+	dnsDomains map[string]struct{}
+	dnsLimit   *seriesLimit
 
 	oomKills int
 
 	mounts map[string]proc.MountInfo
 
-	logParsers map[string]*LogParser
+	logParsers       map[string]*LogParser
+	logPatterns      map[logPatternKey]bool
+	logPatternsLimit *seriesLimit
+	logPatternsLock  sync.Mutex
 
 	hostConntrack *Conntrack
 	nsConntrack   *Conntrack
 	lbConntracks  []*Conntrack
 
-	lock sync.RWMutex
+	lock   sync.RWMutex
+	closed bool // the series budget has been released, so no new series are acquired
+
+	samples containerSamples
 
 	done chan struct{}
 }
@@ -160,11 +243,16 @@ func NewContainer(id ContainerID, cg *cgroup.Cgroup, md *ContainerMetadata, host
 		connectionsByPidFd: map[PidFd]*ActiveConnection{},
 		retransmits:        map[AddrPair]int64{},
 		l7Stats:            L7Stats{},
+		l7Limit:            newSeriesLimit(seriesKindL7, flags.MaxL7DestinationsPerContainer),
 		dnsStats:           &L7Metrics{},
+		dnsDomains:         map[string]struct{}{},
+		dnsLimit:           newSeriesLimit(seriesKindDNS, flags.MaxDNSDomainsPerContainer),
 
 		mounts: map[string]proc.MountInfo{},
 
-		logParsers: map[string]*LogParser{},
+		logParsers:       map[string]*LogParser{},
+		logPatterns:      map[logPatternKey]bool{},
+		logPatternsLimit: newSeriesLimit(seriesKindLogPatterns, flags.MaxLogPatternsPerContainer),
 
 		hostConntrack: hostConntrack,
 
@@ -183,6 +271,7 @@ func NewContainer(id ContainerID, cg *cgroup.Cgroup, md *ContainerMetadata, host
 	}
 
 	c.runLogParser("")
+	c.runSamplers()
 
 	go func() {
 		ticker := time.NewTicker(gcInterval)
@@ -210,6 +299,18 @@ func (c *Container) Close() {
 	if c.nsConntrack != nil {
 		_ = c.nsConntrack.Close()
 	}
+	// the L7 workers may still hold queued requests of the container,
+	// and a concurrent gc must not register its network namespaces again once they are released
+	c.lock.Lock()
+	c.closed = true
+	c.l7Limit.releaseAll()
+	c.dnsLimit.releaseAll()
+	releaseNetNamespaces(c)
+	c.lock.Unlock()
+	governor.RemoveContainer(string(c.id))
+	c.logPatternsLock.Lock()
+	c.logPatternsLimit.releaseAll()
+	c.logPatternsLock.Unlock()
 	close(c.done)
 }
 
@@ -237,7 +338,22 @@ func (c *Container) Collect(ch chan<- prometheus.Metric) {
 			ch <- gauge(metrics.CPULimit, cpu.LimitCores)
 		}
 		ch <- counter(metrics.CPUUsage, cpu.UsageSeconds)
+		if cpu.HasUserSystem {
+			ch <- counter(metrics.CPUUser, cpu.UserSeconds)
+			ch <- counter(metrics.CPUSystem, cpu.SystemSeconds)
+		}
 		ch <- counter(metrics.ThrottledTime, cpu.ThrottledTimeSeconds)
+		ch <- counter(metrics.CPUPeriods, float64(cpu.Periods))
+		ch <- counter(metrics.CPUThrottledPeriods, float64(cpu.ThrottledPeriods))
+		if cpu.Shares > 0 {
+			ch <- gauge(metrics.CPUShares, float64(cpu.Shares))
+		}
+		if cpu.Weight > 0 {
+			ch <- gauge(metrics.CPUWeight, float64(cpu.Weight))
+		}
+		if cpu.CpusetCount > 0 {
+			ch <- gauge(metrics.CPUSet, float64(cpu.CpusetCount), cpu.CpusetCpus)
+		}
 	}
 
 	if taskstatsClient != nil {
@@ -249,18 +365,48 @@ func (c *Container) Collect(ch chan<- prometheus.Metric) {
 	if s, err := c.cgroup.MemoryStat(); err == nil {
 		ch <- gauge(metrics.MemoryRss, float64(s.RSS))
 		ch <- gauge(metrics.MemoryCache, float64(s.Cache))
+		ch <- gauge(metrics.MemoryActiveFile, float64(s.ActiveFile))
+		ch <- gauge(metrics.MemoryInactiveFile, float64(s.InactiveFile))
+		ch <- gauge(metrics.MemoryWorkingSet, float64(s.WorkingSet))
+		ch <- gauge(metrics.MemorySwap, float64(s.Swap))
 		if s.Limit > 0 {
 			ch <- gauge(metrics.MemoryLimit, float64(s.Limit))
 		}
+		if s.High > 0 {
+			ch <- gauge(metrics.MemoryHigh, float64(s.High))
+		}
+		if s.SwapLimit > 0 {
+			ch <- gauge(metrics.MemorySwapLimit, float64(s.SwapLimit))
+		}
+		ch <- counter(metrics.MemoryPageFaults, float64(s.MajorPageFaults), "major")
+		ch <- counter(metrics.MemoryPageFaults, float64(s.PageFaults-s.MajorPageFaults), "minor")
+	}
+
+	if e, err := c.cgroup.MemoryEvents(); err == nil {
+		if c.cgroup.Version != cgroup.V1 {
+			ch <- counter(metrics.MemoryEvents, float64(e.High), "high")
+			ch <- counter(metrics.MemoryEvents, float64(e.OOM), "oom")
+		}
+		ch <- counter(metrics.MemoryEvents, float64(e.Max), "max")
+		ch <- counter(metrics.MemoryEvents, float64(e.OOMKill), "oom_kill")
 	}
 
 	if c.oomKills > 0 {
 		ch <- counter(metrics.OOMKills, float64(c.oomKills))
 	}
 
+	if psi, err := c.cgroup.PSI(); err == nil {
+		collectPSI(ch, metrics.CPUPressure, psi.CPU)
+		collectPSI(ch, metrics.MemoryPressure, psi.Memory)
+		collectPSI(ch, metrics.IOPressure, psi.IO)
+	}
+
+	c.samples.lock.RLock()
+	defer c.samples.lock.RUnlock()
+
 	if disks, err := node.GetDisks(); err == nil {
 		ioStat, _ := c.cgroup.IOStat()
-		for majorMinor, mounts := range c.getMounts() {
+		for majorMinor, mounts := range c.samples.mounts {
 			dev := disks.GetParentBlockDevice(majorMinor)
 			if dev == nil {
 				continue
@@ -310,43 +456,28 @@ func (c *Container) Collect(ch chan<- prometheus.Metric) {
 		ch <- gauge(metrics.NetConnectionsActive, float64(count), d.src.String(), d.dst.String())
 	}
 
-	for source, p := range c.logParsers {
-		for _, c := range p.parser.GetCounters() {
-			ch <- counter(metrics.LogMessages, float64(c.Messages), source, c.Level.String(), c.Hash, c.Sample)
-		}
+	for ns := range c.netNamespaces {
+		ch <- gauge(metrics.NetNamespace, 1, ns)
 	}
 
-	appTypes := map[string]struct{}{}
-	seenJvms := map[string]bool{}
-	for pid, process := range c.processes {
-		cmdline := proc.GetCmdline(pid)
-		if len(cmdline) == 0 {
-			continue
-		}
-		appType := guessApplicationType(cmdline)
-		if appType != "" {
-			appTypes[appType] = struct{}{}
-		}
-		if process.isGolangApp {
-			appTypes["golang"] = struct{}{}
-		}
-		switch {
-		case isJvm(cmdline):
-			jvm, jMetrics := jvmMetrics(pid)
-			if len(jMetrics) > 0 && !seenJvms[jvm] {
-				seenJvms[jvm] = true
-				for _, m := range jMetrics {
-					ch <- m
-				}
-			}
-		case process.dotNetMonitor != nil:
-			appTypes["dotnet"] = struct{}{}
+	c.collectLogMessages(ch)
+
+	for _, m := range c.samples.jvm {
+		ch <- m
+	}
+	dotNet := false
+	for _, process := range c.processes {
+		if process.dotNetMonitor != nil {
+			dotNet = true
 			process.dotNetMonitor.Collect(ch)
 		}
 	}
-	for appType := range appTypes {
+	for appType := range c.samples.appTypes {
 		ch <- gauge(metrics.ApplicationType, 1, appType)
 	}
+	if dotNet {
+		ch <- gauge(metrics.ApplicationType, 1, "dotnet")
+	}
 	if c.dnsStats.Requests != nil {
 		c.dnsStats.Requests.Collect(ch)
 	}
@@ -355,10 +486,8 @@ func (c *Container) Collect(ch chan<- prometheus.Metric) {
 	}
 	c.l7Stats.collect(ch)
 
-	if !*flags.DisablePinger {
-		for ip, rtt := range c.ping() {
-			ch <- gauge(metrics.NetLatency, rtt, ip.String())
-		}
+	for ip, rtt := range c.samples.rtt {
+		ch <- gauge(metrics.NetLatency, rtt, ip.String())
 	}
 }
 
@@ -489,32 +618,33 @@ func (c *Container) onListenClose(pid uint32, addr netaddr.IPPort) {
 	}
 }
 
-func (c *Container) onConnectionOpen(pid uint32, fd uint64, src, dst netaddr.IPPort, timestamp uint64, failed bool) {
+// onConnectionOpen returns true if the connection is tracked as active.
+func (c *Container) onConnectionOpen(pid uint32, fd uint64, src, dst netaddr.IPPort, timestamp uint64, failed bool) bool {
 	if common.PortFilter.ShouldBeSkipped(dst.Port()) {
-		return
+		return false
 	}
 	p := c.processes[pid]
 	if p == nil {
-		return
+		return false
 	}
 	if dst.IP().IsLoopback() && !p.isHostNs() {
-		return
+		return false
 	}
 	actualDst, err := c.getActualDestination(p, src, dst)
 	if err != nil {
 		if !common.IsNotExist(err) {
 			klog.Warningf("cannot open NetNs for pid %d: %s", pid, err)
 		}
-		return
+		return false
 	}
 	switch {
 	case actualDst == nil:
 		actualDst = &dst
 	case actualDst.IP().IsLoopback() && !p.isHostNs():
-		return
+		return false
 	}
 	if common.ConnectionFilter.ShouldBeSkipped(dst.IP(), actualDst.IP()) {
-		return
+		return false
 	}
 	c.lock.Lock()
 	defer c.lock.Unlock()
@@ -533,6 +663,7 @@ func (c *Container) onConnectionOpen(pid uint32, fd uint64, src, dst netaddr.IPP
 		c.connectionsByPidFd[PidFd{Pid: pid, Fd: fd}] = connection
 	}
 	c.connectLastAttempt[dst] = time.Now()
+	return !failed
 }
 
 func (c *Container) getActualDestination(p *Process, src, dst netaddr.IPPort) (*netaddr.IPPort, error) {
@@ -548,7 +679,7 @@ func (c *Container) getActualDestination(p *Process, src, dst netaddr.IPPort) (*
 	if actualDst != nil {
 		return actualDst, nil
 	}
-	if !p.isHostNs() {
+	if !p.isHostNs() && capabilities.Enabled(capabilities.FeatureConntrack) {
 		if c.nsConntrack == nil {
 			netNs, err := proc.GetNetNs(p.Pid)
 			if err != nil {
@@ -576,6 +707,13 @@ func (c *Container) onConnectionClose(srcDst AddrPair) bool {
 	return true
 }
 
+func (c *Container) hasConnection(srcDst AddrPair) bool {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	_, ok := c.connectionsActive[srcDst]
+	return ok
+}
+
 func (c *Container) onDNSRequest(r *l7.RequestData) map[netaddr.IP]string {
 	status := r.Status.DNS()
 	if status == "" {
@@ -592,13 +730,20 @@ func (c *Container) onDNSRequest(r *l7.RequestData) map[netaddr.IP]string {
 			[]string{"request_type", "domain", "status"},
 		)
 	}
-	if m, _ := c.dnsStats.Requests.GetMetricWithLabelValues(t, fqdn, status); m != nil {
+	domain := fqdn
+	if _, ok := c.dnsDomains[domain]; !ok {
+		if c.dnsLimit.acquire(domain) {
+			c.dnsDomains[domain] = struct{}{}
+		} else {
+			domain = overflowLabelValue
+		}
+	}
+	if m, _ := c.dnsStats.Requests.GetMetricWithLabelValues(t, domain, status); m != nil {
 		m.Inc()
 	}
 	if r.Duration != 0 {
 		if c.dnsStats.Latency == nil {
-			dnsLatency := L7Latency[l7.ProtocolDNS]
-			c.dnsStats.Latency = prometheus.NewHistogram(prometheus.HistogramOpts{Name: dnsLatency.Name, Help: dnsLatency.Help})
+			c.dnsStats.Latency = prometheus.NewHistogram(L7Latency[l7.ProtocolDNS])
 		}
 		c.dnsStats.Latency.Observe(r.Duration.Seconds())
 	}
@@ -615,6 +760,9 @@ func (c *Container) onL7Request(pid uint32, fd uint64, timestamp uint64, r *l7.R
 	c.lock.Lock()
 	defer c.lock.Unlock()
 
+	if c.closed {
+		return nil
+	}
 	if r.Protocol == l7.ProtocolDNS {
 		return c.onDNSRequest(r)
 	}
@@ -626,58 +774,69 @@ func (c *Container) onL7Request(pid uint32, fd uint64, timestamp uint64, r *l7.R
 	if timestamp != 0 && conn.Timestamp != timestamp {
 		return nil
 	}
-	stats := c.l7Stats.get(r.Protocol, conn.Dest, conn.ActualDest)
+	stats := c.l7Stats.get(c.l7Limit, r.Protocol, conn.Dest, conn.ActualDest)
 	trace := tracing.NewTrace(string(c.id), conn.ActualDest)
+	payload := r.Payload
+	if !governor.ParseL7(string(c.id)) {
+		trace = nil
+		// the parsers keeping the state of the connection (HPACK tables, prepared statements) are always fed,
+		// otherwise the requests would be decoded incorrectly once parsing resumes
+		switch r.Protocol {
+		case l7.ProtocolHTTP2, l7.ProtocolPostgres, l7.ProtocolMysql:
+		default:
+			payload = nil
+		}
+	}
 	switch r.Protocol {
 	case l7.ProtocolHTTP:
-		stats.observe(r.Status.Http(), "", r.Duration)
-		method, path := l7.ParseHttp(r.Payload)
-		trace.HttpRequest(method, path, r.Status, r.Duration)
+		method, path := l7.ParseHttp(payload)
+		traceId := trace.HttpRequest(method, path, r.Status, r.Duration)
+		stats.observe(r.Status.Http(), "", r.Duration, traceId)
 	case l7.ProtocolHTTP2:
 		if conn.http2Parser == nil {
 			conn.http2Parser = l7.NewHttp2Parser()
 		}
-		requests := conn.http2Parser.Parse(r.Method, r.Payload, uint64(r.Duration))
+		requests := conn.http2Parser.Parse(r.Method, payload, uint64(r.Duration))
 		for _, req := range requests {
-			stats.observe(req.Status.Http(), "", req.Duration)
-			trace.Http2Request(req.Method, req.Path, req.Scheme, req.Status, req.Duration)
+			traceId := trace.Http2Request(req.Method, req.Path, req.Scheme, req.Status, req.Duration)
+			stats.observe(req.Status.Http(), "", req.Duration, traceId)
 		}
 	case l7.ProtocolPostgres:
-		if r.Method != l7.MethodStatementClose {
-			stats.observe(r.Status.String(), "", r.Duration)
-		}
 		if conn.postgresParser == nil {
 			conn.postgresParser = l7.NewPostgresParser()
 		}
-		query := conn.postgresParser.Parse(r.Payload)
-		trace.PostgresQuery(query, r.Status.Error(), r.Duration)
-	case l7.ProtocolMysql:
+		query := conn.postgresParser.Parse(payload)
+		traceId := trace.PostgresQuery(query, r.Status.Error(), r.Duration)
 		if r.Method != l7.MethodStatementClose {
-			stats.observe(r.Status.String(), "", r.Duration)
+			stats.observe(r.Status.String(), "", r.Duration, traceId)
 		}
+	case l7.ProtocolMysql:
 		if conn.mysqlParser == nil {
 			conn.mysqlParser = l7.NewMysqlParser()
 		}
-		query := conn.mysqlParser.Parse(r.Payload, r.StatementId)
-		trace.MysqlQuery(query, r.Status.Error(), r.Duration)
+		query := conn.mysqlParser.Parse(payload, r.StatementId)
+		traceId := trace.MysqlQuery(query, r.Status.Error(), r.Duration)
+		if r.Method != l7.MethodStatementClose {
+			stats.observe(r.Status.String(), "", r.Duration, traceId)
+		}
 	case l7.ProtocolMemcached:
-		stats.observe(r.Status.String(), "", r.Duration)
-		cmd, items := l7.ParseMemcached(r.Payload)
-		trace.MemcachedQuery(cmd, items, r.Status.Error(), r.Duration)
+		cmd, items := l7.ParseMemcached(payload)
+		traceId := trace.MemcachedQuery(cmd, items, r.Status.Error(), r.Duration)
+		stats.observe(r.Status.String(), "", r.Duration, traceId)
 	case l7.ProtocolRedis:
-		stats.observe(r.Status.String(), "", r.Duration)
-		cmd, args := l7.ParseRedis(r.Payload)
-		trace.RedisQuery(cmd, args, r.Status.Error(), r.Duration)
+		cmd, args := l7.ParseRedis(payload)
+		traceId := trace.RedisQuery(cmd, args, r.Status.Error(), r.Duration)
+		stats.observe(r.Status.String(), "", r.Duration, traceId)
 	case l7.ProtocolMongo:
-		stats.observe(r.Status.String(), "", r.Duration)
-		query := l7.ParseMongo(r.Payload)
-		trace.MongoQuery(query, r.Status.Error(), r.Duration)
+		query := l7.ParseMongo(payload)
+		traceId := trace.MongoQuery(query, r.Status.Error(), r.Duration)
+		stats.observe(r.Status.String(), "", r.Duration, traceId)
 	case l7.ProtocolKafka, l7.ProtocolCassandra:
-		stats.observe(r.Status.String(), "", r.Duration)
+		stats.observe(r.Status.String(), "", r.Duration, "")
 	case l7.ProtocolRabbitmq, l7.ProtocolNats:
-		stats.observe(r.Status.String(), r.Method.String(), 0)
+		stats.observe(r.Status.String(), r.Method.String(), 0, "")
 	case l7.ProtocolDubbo2:
-		stats.observe(r.Status.String(), "", r.Duration)
+		stats.observe(r.Status.String(), "", r.Duration, "")
 	}
 	return nil
 }
@@ -711,19 +870,23 @@ func (c *Container) updateDelays() {
 }
 
 func (c *Container) getMounts() map[string]map[string]*proc.FSStat {
-	if len(c.mounts) == 0 {
+	c.lock.RLock()
+	mounts := make([]proc.MountInfo, 0, len(c.mounts))
+	for _, mi := range c.mounts {
+		mounts = append(mounts, mi)
+	}
+	pids := make([]uint32, 0, len(c.processes))
+	for pid := range c.processes {
+		pids = append(pids, pid)
+	}
+	c.lock.RUnlock()
+
+	if len(mounts) == 0 {
 		return nil
 	}
 	res := map[string]map[string]*proc.FSStat{}
-	for _, mi := range c.mounts {
-		var stat *proc.FSStat
-		for pid := range c.processes {
-			s, err := proc.StatFS(proc.Path(pid, "root", mi.MountPoint))
-			if err == nil {
-				stat = &s
-				break
-			}
-		}
+	for _, mi := range mounts {
+		stat := statFS(mi, pids)
 		if stat == nil {
 			continue
 		}
@@ -818,8 +981,25 @@ func (c *Container) getProxiedListens() map[string]map[netaddr.IPPort]struct{} {
 }
 
 func (c *Container) ping() map[netaddr.IP]float64 {
-	netNs := netns.None()
+	c.lock.RLock()
+	pids := make([]uint32, 0, len(c.processes))
 	for pid := range c.processes {
+		pids = append(pids, pid)
+	}
+	ips := map[netaddr.IP]struct{}{}
+	for d := range c.connectsSuccessful {
+		ips[d.dst.IP()] = struct{}{}
+	}
+	for dst := range c.connectsFailed {
+		ips[dst.IP()] = struct{}{}
+	}
+	c.lock.RUnlock()
+
+	if len(ips) == 0 {
+		return nil
+	}
+	netNs := netns.None()
+	for _, pid := range pids {
 		if pid == agentPid {
 			netNs = selfNetNs
 			break
@@ -838,17 +1018,6 @@ func (c *Container) ping() map[netaddr.IP]float64 {
 	if !netNs.IsOpen() {
 		return nil
 	}
-
-	ips := map[netaddr.IP]struct{}{}
-	for d := range c.connectsSuccessful {
-		ips[d.dst.IP()] = struct{}{}
-	}
-	for dst := range c.connectsFailed {
-		ips[dst.IP()] = struct{}{}
-	}
-	if len(ips) == 0 {
-		return nil
-	}
 	targets := make([]netaddr.IP, 0, len(ips))
 	for ip := range ips {
 		if ip.IsLoopback() {
@@ -878,8 +1047,7 @@ func (c *Container) runLogParser(logPath string) {
 		if c.logParsers[logPath] != nil {
 			return
 		}
-		ch := make(chan logparser.LogEntry)
-		parser := logparser.NewParser(ch, nil, logs.OtelLogEmitter(containerId))
+		parser, ch := newLogParser(nil, containerId)
 		reader, err := logs.NewTailReader(proc.HostPath(logPath), ch)
 		if err != nil {
 			klog.Warningln(err)
@@ -887,23 +1055,24 @@ func (c *Container) runLogParser(logPath string) {
 			return
 		}
 		klog.InfoS("started varlog logparser", "cg", c.cgroup.Id, "log", logPath)
-		c.logParsers[logPath] = &LogParser{parser: parser, stop: reader.Stop}
+		parser.stop = reader.Stop
+		c.logParsers[logPath] = parser
 		return
 	}
 
 	switch c.cgroup.ContainerType {
 	case cgroup.ContainerTypeSystemdService:
-		ch := make(chan logparser.LogEntry)
+		parser, ch := newLogParser(nil, containerId)
 		if err := JournaldSubscribe(c.cgroup, ch); err != nil {
 			klog.Warningln(err)
+			parser.Stop()
 			return
 		}
-		parser := logparser.NewParser(ch, nil, logs.OtelLogEmitter(containerId))
-		stop := func() {
+		parser.stop = func() {
 			JournaldUnsubscribe(c.cgroup)
 		}
 		klog.InfoS("started journald logparser", "cg", c.cgroup.Id)
-		c.logParsers["journald"] = &LogParser{parser: parser, stop: stop}
+		c.logParsers["journald"] = parser
 
 	case cgroup.ContainerTypeDocker, cgroup.ContainerTypeContainerd, cgroup.ContainerTypeCrio:
 		if c.metadata.logPath == "" {
@@ -913,8 +1082,7 @@ func (c *Container) runLogParser(logPath string) {
 			parser.Stop()
 			delete(c.logParsers, "stdout/stderr")
 		}
-		ch := make(chan logparser.LogEntry)
-		parser := logparser.NewParser(ch, c.metadata.logDecoder, logs.OtelLogEmitter(containerId))
+		parser, ch := newLogParser(c.metadata.logDecoder, containerId)
 		reader, err := logs.NewTailReader(proc.HostPath(c.metadata.logPath), ch)
 		if err != nil {
 			klog.Warningln(err)
@@ -922,18 +1090,64 @@ func (c *Container) runLogParser(logPath string) {
 			return
 		}
 		klog.InfoS("started container logparser", "cg", c.cgroup.Id)
-		c.logParsers["stdout/stderr"] = &LogParser{parser: parser, stop: reader.Stop}
+		parser.stop = reader.Stop
+		c.logParsers["stdout/stderr"] = parser
+	}
+}
+
+func collectPSI(ch chan<- prometheus.Metric, desc *prometheus.Desc, psi *cgroup.PSI) {
+	if psi == nil {
+		return
+	}
+	ch <- counter(desc, psi.SomeSeconds, "some")
+	if psi.HasFull {
+		ch <- counter(desc, psi.FullSeconds, "full")
+	}
+}
+
+type logPatternKey struct {
+	source string
+	hash   string
+}
+
+// collectLogMessages reports the messages of the patterns beyond the limit and the messages counted while log parsing
+// was shed as a single `__other__` pattern per source and level.
+func (c *Container) collectLogMessages(ch chan<- prometheus.Metric) {
+	c.logPatternsLock.Lock()
+	defer c.logPatternsLock.Unlock()
+	for source, p := range c.logParsers {
+		overflow := p.shedMessages()
+		for _, lc := range p.parser.GetCounters() {
+			k := logPatternKey{source: source, hash: lc.Hash}
+			allowed, seen := c.logPatterns[k]
+			if !seen {
+				allowed = c.logPatternsLimit.acquire(source + ":" + lc.Hash)
+				c.logPatterns[k] = allowed
+			}
+			if !allowed {
+				overflow[lc.Level] += lc.Messages
+				continue
+			}
+			ch <- counter(metrics.LogMessages, float64(lc.Messages), source, lc.Level.String(), lc.Hash, lc.Sample)
+		}
+		for level, messages := range overflow {
+			ch <- counter(metrics.LogMessages, float64(messages), source, level.String(), overflowLabelValue, "")
+		}
 	}
 }
 
 func (c *Container) gc(now time.Time) {
 	c.lock.Lock()
 	defer c.lock.Unlock()
+	if c.closed {
+		return
+	}
 
 	established := map[AddrPair]struct{}{}
 	establishedDst := map[netaddr.IPPort]struct{}{}
 	listens := map[netaddr.IPPort]string{}
 	seenNamespaces := map[string]bool{}
+	netNamespaces := map[string]uint32{}
 	for _, p := range c.processes {
 		if seenNamespaces[p.NetNsId()] {
 			continue
@@ -951,7 +1165,11 @@ func (c *Container) gc(now time.Time) {
 			}
 		}
 		seenNamespaces[p.NetNsId()] = true
+		if !p.isHostNs() {
+			netNamespaces[p.NetNsId()] = p.Pid
+		}
 	}
+	setNetNamespaces(c, netNamespaces)
 
 	for ns := range c.ipsByNs {
 		if !seenNamespaces[ns] {
@@ -992,7 +1210,7 @@ func (c *Container) gc(now time.Time) {
 					delete(c.retransmits, d)
 				}
 			}
-			c.l7Stats.delete(dst)
+			c.l7Stats.delete(c.l7Limit, dst)
 		}
 	}
 }
@@ -1065,19 +1283,22 @@ func (c *Container) revalidateListens(now time.Time, actualListens map[netaddr.I
 	}
 }
 
-func (c *Container) attachTlsUprobes(tracer *ebpftracer.Tracer, pid uint32) {
+func (c *Container) attachTlsUprobes(tracer eventTracer, pid uint32) {
 	p := c.processes[pid]
 	if p == nil {
 		return
 	}
 	if !p.openSslUprobesChecked {
-		p.uprobes = append(p.uprobes, tracer.AttachOpenSslUprobes(pid)...)
+		uprobes := tracer.AttachOpenSslUprobes(pid)
+		p.uprobes = append(p.uprobes, uprobes...)
+		p.openSslUprobes = len(uprobes)
 		p.openSslUprobesChecked = true
 	}
 	if !p.goTlsUprobesChecked {
 		uprobes, isGolangApp := tracer.AttachGoTlsUprobes(pid)
 		p.isGolangApp = isGolangApp
 		p.uprobes = append(p.uprobes, uprobes...)
+		p.goTlsUprobes = len(uprobes)
 		p.goTlsUprobesChecked = true
 	}
 }
@@ -74,6 +74,7 @@ func ContainerdInspect(containerID string) (*ContainerMetadata, error) {
 		for _, m := range spec.Mounts {
 			res.volumes[m.Destination] = common.ParseKubernetesVolumeSource(m.Source)
 		}
+		res.annotations = spec.Annotations
 	}
 
 	if data, ok := c.Extensions["io.cri-containerd.container.metadata"]; ok {
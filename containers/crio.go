@@ -81,6 +81,12 @@ func CrioInspect(containerID string) (*ContainerMetadata, error) {
 		logDecoder: logparser.CriDecoder{},
 	}
 
+	if a := i.CrioAnnotations["io.kubernetes.cri-o.Annotations"]; a != "" {
+		if err := json.Unmarshal([]byte(a), &res.annotations); err != nil {
+			klog.Warningln(err)
+		}
+	}
+
 	var volumes []CrioVolume
 
 	if err := json.Unmarshal([]byte(i.CrioAnnotations["io.kubernetes.cri-o.Volumes"]), &volumes); err != nil {
@@ -61,6 +61,14 @@ func DockerdInspect(containerID string) (*ContainerMetadata, error) {
 	for _, m := range c.Mounts {
 		res.volumes[m.Destination] = common.ParseKubernetesVolumeSource(m.Source)
 	}
+	for k, v := range c.Config.Labels {
+		if key, ok := strings.CutPrefix(k, "annotation."); ok { // dockershim
+			if res.annotations == nil {
+				res.annotations = map[string]string{}
+			}
+			res.annotations[key] = v
+		}
+	}
 	if c.LogPath != "" && c.HostConfig.LogConfig.Type == "json-file" {
 		res.logPath = c.LogPath
 		res.logDecoder = logparser.DockerJsonDecoder{}
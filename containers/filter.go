@@ -0,0 +1,147 @@
+package containers
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/coroot/coroot-node-agent/cgroup"
+)
+
+// containerFilter decides which containers are tracked.
+// A rule is a comma-separated list of conditions `<field>=<pattern>`, all of which must match.
+// Supported fields: id, namespace, image, cgroup, systemd_unit, label:<key> and annotation:<key>.
+// Patterns may contain `*` matching any sequence of characters.
+// If there are include rules, a container must match at least one of them. A container matching any exclude rule is ignored.
+type containerFilter struct {
+	include []containerFilterRule
+	exclude []containerFilterRule
+}
+
+type containerFilterRule []containerFilterCondition
+
+type containerFilterCondition struct {
+	field   string
+	key     string
+	pattern *regexp.Regexp
+}
+
+func newContainerFilter(include, exclude []string) (*containerFilter, error) {
+	f := &containerFilter{}
+	for _, r := range include {
+		rule, err := parseContainerFilterRule(r)
+		if err != nil {
+			return nil, err
+		}
+		f.include = append(f.include, rule)
+	}
+	for _, r := range exclude {
+		rule, err := parseContainerFilterRule(r)
+		if err != nil {
+			return nil, err
+		}
+		f.exclude = append(f.exclude, rule)
+	}
+	return f, nil
+}
+
+func parseContainerFilterRule(s string) (containerFilterRule, error) {
+	var rule containerFilterRule
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		field, pattern, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid container filter condition %q, expected <field>=<pattern>", part)
+		}
+		c := containerFilterCondition{field: strings.TrimSpace(field), pattern: globToRegexp(strings.TrimSpace(pattern))}
+		switch {
+		case c.field == "id", c.field == "namespace", c.field == "image", c.field == "cgroup", c.field == "systemd_unit":
+		case strings.HasPrefix(c.field, "label:"), strings.HasPrefix(c.field, "annotation:"):
+			c.field, c.key, _ = strings.Cut(c.field, ":")
+			if c.key == "" {
+				return nil, fmt.Errorf("invalid container filter condition %q, the %s key is empty", part, c.field)
+			}
+		default:
+			return nil, fmt.Errorf("invalid container filter condition %q, unknown field %q", part, c.field)
+		}
+		rule = append(rule, c)
+	}
+	if len(rule) == 0 {
+		return nil, fmt.Errorf("empty container filter rule")
+	}
+	return rule, nil
+}
+
+func globToRegexp(glob string) *regexp.Regexp {
+	parts := strings.Split(glob, "*")
+	for i, p := range parts {
+		parts[i] = regexp.QuoteMeta(p)
+	}
+	return regexp.MustCompile("^" + strings.Join(parts, ".*") + "$")
+}
+
+func (f *containerFilter) allowed(id ContainerID, cg *cgroup.Cgroup, md *ContainerMetadata) bool {
+	if f == nil {
+		return true
+	}
+	for _, r := range f.exclude {
+		if r.match(id, cg, md) {
+			return false
+		}
+	}
+	if len(f.include) == 0 {
+		return true
+	}
+	for _, r := range f.include {
+		if r.match(id, cg, md) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r containerFilterRule) match(id ContainerID, cg *cgroup.Cgroup, md *ContainerMetadata) bool {
+	for _, c := range r {
+		var value string
+		var ok bool
+		switch c.field {
+		case "id":
+			value, ok = string(id), true
+		case "namespace":
+			value, ok = containerNamespace(id)
+		case "image":
+			value, ok = md.image, md.image != ""
+		case "cgroup":
+			value, ok = cg.Id, true
+		case "systemd_unit":
+			if cg.ContainerType == cgroup.ContainerTypeSystemdService {
+				value, ok = path.Base(cg.ContainerId), true
+			}
+		case "label":
+			value, ok = md.labels[c.key]
+		case "annotation":
+			value, ok = md.annotations[c.key]
+		}
+		if !ok || !c.pattern.MatchString(value) {
+			return false
+		}
+	}
+	return true
+}
+
+// containerNamespace returns the namespace of a Kubernetes, Swarm or Nomad container from its ID.
+func containerNamespace(id ContainerID) (string, bool) {
+	parts := strings.Split(string(id), "/")
+	if len(parts) < 3 {
+		return "", false
+	}
+	switch parts[1] {
+	case "k8s", "swarm", "nomad":
+		return parts[2], true
+	}
+	return "", false
+}
@@ -0,0 +1,147 @@
+package containers
+
+import (
+	"testing"
+
+	"github.com/coroot/coroot-node-agent/cgroup"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseContainerFilterRule(t *testing.T) {
+	for _, tc := range []struct {
+		rule string
+		err  string
+	}{
+		{rule: "id=/k8s/*"},
+		{rule: " namespace = prod* , image=nginx:* "},
+		{rule: "cgroup=/system.slice/*,systemd_unit=*.service"},
+		{rule: "label:app=web,annotation:team=*"},
+		{rule: "label:app.kubernetes.io/name=web"},
+		{rule: "", err: "empty container filter rule"},
+		{rule: " , ", err: "empty container filter rule"},
+		{rule: "namespace", err: `invalid container filter condition "namespace", expected <field>=<pattern>`},
+		{rule: "pod=web", err: `unknown field "pod"`},
+		{rule: "label:=web", err: "the label key is empty"},
+		{rule: "annotation:=web", err: "the annotation key is empty"},
+		{rule: "labels:app=web", err: `unknown field "labels:app"`},
+	} {
+		t.Run(tc.rule, func(t *testing.T) {
+			_, err := parseContainerFilterRule(tc.rule)
+			if tc.err == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorContains(t, err, tc.err)
+			}
+		})
+	}
+
+	_, err := newContainerFilter([]string{"namespace=prod"}, []string{"image"})
+	assert.Error(t, err)
+}
+
+func TestGlobToRegexp(t *testing.T) {
+	for _, tc := range []struct {
+		glob    string
+		value   string
+		matched bool
+	}{
+		{glob: "web", value: "web", matched: true},
+		{glob: "web", value: "web-1", matched: false},
+		{glob: "web*", value: "web-1", matched: true},
+		{glob: "*web", value: "my-web", matched: true},
+		{glob: "*-web-*", value: "prod-web-1", matched: true},
+		{glob: "*", value: "", matched: true},
+		{glob: "", value: "", matched: true},
+		{glob: "", value: "web", matched: false},
+		{glob: "nginx:1.2", value: "nginx:1x2", matched: false},
+		{glob: "app.(v1)+", value: "app.(v1)+", matched: true},
+	} {
+		assert.Equal(t, tc.matched, globToRegexp(tc.glob).MatchString(tc.value), "%s ~ %s", tc.glob, tc.value)
+	}
+}
+
+func TestContainerFilter(t *testing.T) {
+	type container struct {
+		id ContainerID
+		cg *cgroup.Cgroup
+		md *ContainerMetadata
+	}
+	web := container{
+		id: "/k8s/prod/web-7d4f/app",
+		cg: &cgroup.Cgroup{Id: "/kubepods/burstable/pod1/abc", ContainerType: cgroup.ContainerTypeContainerd},
+		md: &ContainerMetadata{
+			image:       "registry.local/web:1.2",
+			labels:      map[string]string{"app": "web"},
+			annotations: map[string]string{"team": "frontend"},
+		},
+	}
+	nginx := container{
+		id: "/swarm/staging/nginx/1",
+		cg: &cgroup.Cgroup{Id: "/docker/def", ContainerType: cgroup.ContainerTypeDocker},
+		md: &ContainerMetadata{image: "nginx:1.25"},
+	}
+	postgres := container{
+		id: "/system.slice/postgresql.service",
+		cg: &cgroup.Cgroup{Id: "/system.slice/postgresql.service", ContainerId: "/system.slice/postgresql.service", ContainerType: cgroup.ContainerTypeSystemdService},
+		md: &ContainerMetadata{},
+	}
+	all := []container{web, nginx, postgres}
+
+	for _, tc := range []struct {
+		name    string
+		include []string
+		exclude []string
+		allowed []ContainerID
+	}{
+		{name: "no rules", allowed: []ContainerID{web.id, nginx.id, postgres.id}},
+		{name: "id", include: []string{"id=/k8s/*"}, allowed: []ContainerID{web.id}},
+		{name: "namespace", include: []string{"namespace=prod"}, allowed: []ContainerID{web.id}},
+		{name: "swarm namespace", include: []string{"namespace=stag*"}, allowed: []ContainerID{nginx.id}},
+		{name: "image", include: []string{"image=*/web:*"}, allowed: []ContainerID{web.id}},
+		{name: "any image", exclude: []string{"image=*"}, allowed: []ContainerID{postgres.id}},
+		{name: "cgroup", include: []string{"cgroup=/docker/*"}, allowed: []ContainerID{nginx.id}},
+		{name: "systemd unit", include: []string{"systemd_unit=postgresql.service"}, allowed: []ContainerID{postgres.id}},
+		{name: "systemd unit of a container", include: []string{"systemd_unit=*"}, allowed: []ContainerID{postgres.id}},
+		{name: "label", include: []string{"label:app=web"}, allowed: []ContainerID{web.id}},
+		{name: "missing label", exclude: []string{"label:app=*"}, allowed: []ContainerID{nginx.id, postgres.id}},
+		{name: "annotation", include: []string{"annotation:team=front*"}, allowed: []ContainerID{web.id}},
+		{name: "all conditions of a rule", include: []string{"namespace=prod,label:app=db"}},
+		{name: "any include rule", include: []string{"namespace=prod", "image=nginx:*"}, allowed: []ContainerID{web.id, nginx.id}},
+		{name: "exclude takes precedence", include: []string{"id=*"}, exclude: []string{"image=nginx:*"}, allowed: []ContainerID{web.id, postgres.id}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f, err := newContainerFilter(tc.include, tc.exclude)
+			require.NoError(t, err)
+			var allowed []ContainerID
+			for _, c := range all {
+				if f.allowed(c.id, c.cg, c.md) {
+					allowed = append(allowed, c.id)
+				}
+			}
+			assert.Equal(t, tc.allowed, allowed)
+		})
+	}
+
+	var f *containerFilter
+	assert.True(t, f.allowed(web.id, web.cg, web.md))
+}
+
+func TestContainerNamespace(t *testing.T) {
+	for _, tc := range []struct {
+		id        ContainerID
+		namespace string
+		ok        bool
+	}{
+		{id: "/k8s/default/web/app", namespace: "default", ok: true},
+		{id: "/swarm/staging/nginx/1", namespace: "staging", ok: true},
+		{id: "/nomad/jobs/alloc/task", namespace: "jobs", ok: true},
+		{id: "/docker/nginx", ok: false},
+		{id: "/system.slice/nginx.service", ok: false},
+		{id: "/k8s", ok: false},
+	} {
+		ns, ok := containerNamespace(tc.id)
+		assert.Equal(t, tc.namespace, ns, tc.id)
+		assert.Equal(t, tc.ok, ok, tc.id)
+	}
+}
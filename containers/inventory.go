@@ -0,0 +1,203 @@
+package containers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+type InventoryFilter struct {
+	ContainerId ContainerID
+	Pid         uint32
+}
+
+type InventoryContainer struct {
+	Id          ContainerID           `json:"id"`
+	CgroupId    string                `json:"cgroup_id"`
+	Type        string                `json:"type"`
+	Name        string                `json:"name,omitempty"`
+	Image       string                `json:"image,omitempty"`
+	Labels      map[string]string     `json:"labels,omitempty"`
+	StartedAt   time.Time             `json:"started_at"`
+	Restarts    int                   `json:"restarts"`
+	Processes   []InventoryProcess    `json:"processes"`
+	Listens     []InventoryListen     `json:"listens"`
+	Connections []InventoryConnection `json:"connections"`
+	LogParsers  []string              `json:"log_parsers"`
+}
+
+type InventoryProcess struct {
+	Pid            uint32    `json:"pid"`
+	StartedAt      time.Time `json:"started_at"`
+	GolangApp      bool      `json:"golang_app"`
+	OpenSslUprobes int       `json:"openssl_uprobes"`
+	GoTlsUprobes   int       `json:"go_tls_uprobes"`
+}
+
+type InventoryListen struct {
+	Addr     string     `json:"addr"`
+	Pid      uint32     `json:"pid"`
+	ClosedAt *time.Time `json:"closed_at,omitempty"`
+}
+
+type InventoryConnection struct {
+	Pid        uint32     `json:"pid"`
+	Fd         uint64     `json:"fd"`
+	Src        string     `json:"src"`
+	Dest       string     `json:"dest"`
+	ActualDest string     `json:"actual_dest"`
+	ClosedAt   *time.Time `json:"closed_at,omitempty"`
+}
+
+type inventoryRequest struct {
+	filter InventoryFilter
+	res    chan []InventoryContainer
+}
+
+// Inventory returns a snapshot of the containers. The request is served by the events loop, which owns the registry.
+func (r *Registry) Inventory(ctx context.Context, filter InventoryFilter) ([]InventoryContainer, error) {
+	req := inventoryRequest{filter: filter, res: make(chan []InventoryContainer, 1)}
+	select {
+	case r.inventoryRequests <- req:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	select {
+	case res := <-req.res:
+		return res, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (r *Registry) InventoryHandler(w http.ResponseWriter, req *http.Request) {
+	filter := InventoryFilter{ContainerId: ContainerID(req.URL.Query().Get("container_id"))}
+	if pid := req.URL.Query().Get("pid"); pid != "" {
+		v, err := strconv.ParseUint(pid, 10, 32)
+		if err != nil {
+			http.Error(w, "invalid pid: "+pid, http.StatusBadRequest)
+			return
+		}
+		filter.Pid = uint32(v)
+	}
+	res, err := r.Inventory(req.Context(), filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(res); err != nil {
+		klog.Errorln(err)
+	}
+}
+
+func (r *Registry) inventory(filter InventoryFilter) []InventoryContainer {
+	var cs []*Container
+	switch {
+	case filter.Pid != 0:
+		if c := r.containersByPid[filter.Pid]; c != nil && (filter.ContainerId == "" || c.id == filter.ContainerId) {
+			cs = append(cs, c)
+		}
+	case filter.ContainerId != "":
+		if c := r.containersById[filter.ContainerId]; c != nil {
+			cs = append(cs, c)
+		}
+	default:
+		for _, c := range r.containersById {
+			cs = append(cs, c)
+		}
+	}
+	res := make([]InventoryContainer, 0, len(cs))
+	for _, c := range cs {
+		res = append(res, c.inventory(filter.Pid))
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Id < res[j].Id
+	})
+	return res
+}
+
+func (c *Container) inventory(pid uint32) InventoryContainer {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	res := InventoryContainer{
+		Id:          c.id,
+		CgroupId:    c.cgroup.Id,
+		Type:        c.cgroup.ContainerType.String(),
+		Name:        c.metadata.name,
+		Image:       c.metadata.image,
+		Labels:      c.metadata.labels,
+		StartedAt:   c.startedAt,
+		Restarts:    c.restarts,
+		Processes:   []InventoryProcess{},
+		Listens:     []InventoryListen{},
+		Connections: []InventoryConnection{},
+		LogParsers:  []string{},
+	}
+	for _, p := range c.processes {
+		if pid != 0 && p.Pid != pid {
+			continue
+		}
+		res.Processes = append(res.Processes, InventoryProcess{
+			Pid:            p.Pid,
+			StartedAt:      p.StartedAt,
+			GolangApp:      p.isGolangApp,
+			OpenSslUprobes: p.openSslUprobes,
+			GoTlsUprobes:   p.goTlsUprobes,
+		})
+	}
+	sort.Slice(res.Processes, func(i, j int) bool {
+		return res.Processes[i].Pid < res.Processes[j].Pid
+	})
+	for addr, byPid := range c.listens {
+		for p, details := range byPid {
+			if pid != 0 && p != pid {
+				continue
+			}
+			l := InventoryListen{Addr: addr.String(), Pid: p}
+			if t := details.ClosedAt; !t.IsZero() {
+				l.ClosedAt = &t
+			}
+			res.Listens = append(res.Listens, l)
+		}
+	}
+	sort.Slice(res.Listens, func(i, j int) bool {
+		if res.Listens[i].Addr == res.Listens[j].Addr {
+			return res.Listens[i].Pid < res.Listens[j].Pid
+		}
+		return res.Listens[i].Addr < res.Listens[j].Addr
+	})
+	for addrs, conn := range c.connectionsActive {
+		if pid != 0 && conn.Pid != pid {
+			continue
+		}
+		ic := InventoryConnection{
+			Pid:        conn.Pid,
+			Fd:         conn.Fd,
+			Src:        addrs.src.String(),
+			Dest:       conn.Dest.String(),
+			ActualDest: conn.ActualDest.String(),
+		}
+		if t := conn.Closed; !t.IsZero() {
+			ic.ClosedAt = &t
+		}
+		res.Connections = append(res.Connections, ic)
+	}
+	sort.Slice(res.Connections, func(i, j int) bool {
+		if res.Connections[i].Pid == res.Connections[j].Pid {
+			return res.Connections[i].Fd < res.Connections[j].Fd
+		}
+		return res.Connections[i].Pid < res.Connections[j].Pid
+	})
+	for source := range c.logParsers {
+		res.LogParsers = append(res.LogParsers, source)
+	}
+	sort.Strings(res.LogParsers)
+	return res
+}
@@ -0,0 +1,183 @@
+package containers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/coroot/coroot-node-agent/cgroup"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"inet.af/netaddr"
+)
+
+func testInventoryRegistry() *Registry {
+	started := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	closed := started.Add(time.Minute)
+	web := &Container{
+		id:       "/k8s/default/web/app",
+		cgroup:   &cgroup.Cgroup{Id: "/kubepods/pod1/abc", ContainerType: cgroup.ContainerTypeContainerd},
+		metadata: &ContainerMetadata{name: "app", image: "web:1.0", labels: map[string]string{"app": "web"}},
+		processes: map[uint32]*Process{
+			20: {Pid: 20, StartedAt: started, isGolangApp: true, goTlsUprobes: 3},
+			10: {Pid: 10, StartedAt: started, openSslUprobes: 2},
+		},
+		startedAt: started,
+		restarts:  1,
+		listens: map[netaddr.IPPort]map[uint32]*ListenDetails{
+			netaddr.MustParseIPPort("0.0.0.0:8080"): {20: {}, 10: {ClosedAt: closed}},
+		},
+		connectionsActive: map[AddrPair]*ActiveConnection{
+			{src: netaddr.MustParseIPPort("10.0.0.1:40000"), dst: netaddr.MustParseIPPort("10.96.0.10:5432")}: {
+				Dest:       netaddr.MustParseIPPort("10.96.0.10:5432"),
+				ActualDest: netaddr.MustParseIPPort("10.0.0.2:5432"),
+				Pid:        20,
+				Fd:         7,
+			},
+			{src: netaddr.MustParseIPPort("10.0.0.1:40001"), dst: netaddr.MustParseIPPort("10.0.0.3:6379")}: {
+				Dest:       netaddr.MustParseIPPort("10.0.0.3:6379"),
+				ActualDest: netaddr.MustParseIPPort("10.0.0.3:6379"),
+				Pid:        10,
+				Fd:         5,
+				Closed:     closed,
+			},
+		},
+		logParsers: map[string]*LogParser{"stdout/stderr": nil, "journald": nil},
+	}
+	db := &Container{
+		id:        "/system.slice/postgresql.service",
+		cgroup:    &cgroup.Cgroup{Id: "/system.slice/postgresql.service", ContainerType: cgroup.ContainerTypeSystemdService},
+		metadata:  &ContainerMetadata{},
+		processes: map[uint32]*Process{30: {Pid: 30, StartedAt: started}},
+		startedAt: started,
+	}
+	return &Registry{
+		containersById:  map[ContainerID]*Container{web.id: web, db.id: db},
+		containersByPid: map[uint32]*Container{10: web, 20: web, 30: db},
+	}
+}
+
+func TestInventoryFilter(t *testing.T) {
+	r := testInventoryRegistry()
+	ids := func(cs []InventoryContainer) []ContainerID {
+		var res []ContainerID
+		for _, c := range cs {
+			res = append(res, c.Id)
+		}
+		return res
+	}
+	pids := func(cs []InventoryContainer) []uint32 {
+		var res []uint32
+		for _, c := range cs {
+			for _, p := range c.Processes {
+				res = append(res, p.Pid)
+			}
+		}
+		return res
+	}
+
+	for _, tc := range []struct {
+		name   string
+		filter InventoryFilter
+		ids    []ContainerID
+		pids   []uint32
+	}{
+		{name: "all", filter: InventoryFilter{}, ids: []ContainerID{"/k8s/default/web/app", "/system.slice/postgresql.service"}, pids: []uint32{10, 20, 30}},
+		{name: "container", filter: InventoryFilter{ContainerId: "/k8s/default/web/app"}, ids: []ContainerID{"/k8s/default/web/app"}, pids: []uint32{10, 20}},
+		{name: "unknown container", filter: InventoryFilter{ContainerId: "/k8s/default/web/unknown"}},
+		{name: "pid", filter: InventoryFilter{Pid: 20}, ids: []ContainerID{"/k8s/default/web/app"}, pids: []uint32{20}},
+		{name: "unknown pid", filter: InventoryFilter{Pid: 40}},
+		{name: "pid and container", filter: InventoryFilter{ContainerId: "/k8s/default/web/app", Pid: 10}, ids: []ContainerID{"/k8s/default/web/app"}, pids: []uint32{10}},
+		{name: "pid of another container", filter: InventoryFilter{ContainerId: "/k8s/default/web/app", Pid: 30}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			res := r.inventory(tc.filter)
+			assert.NotNil(t, res)
+			assert.Equal(t, tc.ids, ids(res))
+			assert.Equal(t, tc.pids, pids(res))
+		})
+	}
+
+	res := r.inventory(InventoryFilter{Pid: 10})
+	require.Len(t, res, 1)
+	assert.Len(t, res[0].Listens, 1)
+	assert.Equal(t, uint32(10), res[0].Listens[0].Pid)
+	require.Len(t, res[0].Connections, 1)
+	assert.Equal(t, uint64(5), res[0].Connections[0].Fd)
+}
+
+func TestInventorySerialization(t *testing.T) {
+	r := testInventoryRegistry()
+	r.inventoryRequests = make(chan inventoryRequest)
+	go func() {
+		for req := range r.inventoryRequests {
+			req.res <- r.inventory(req.filter)
+		}
+	}()
+	defer close(r.inventoryRequests)
+
+	for _, tc := range []struct {
+		name   string
+		query  string
+		status int
+		body   string
+	}{
+		{
+			name:   "container",
+			query:  "?container_id=/k8s/default/web/app",
+			status: http.StatusOK,
+			body: `[{
+				"id": "/k8s/default/web/app",
+				"cgroup_id": "/kubepods/pod1/abc",
+				"type": "cri-containerd",
+				"name": "app",
+				"image": "web:1.0",
+				"labels": {"app": "web"},
+				"started_at": "2024-01-02T03:04:05Z",
+				"restarts": 1,
+				"processes": [
+					{"pid": 10, "started_at": "2024-01-02T03:04:05Z", "golang_app": false, "openssl_uprobes": 2, "go_tls_uprobes": 0},
+					{"pid": 20, "started_at": "2024-01-02T03:04:05Z", "golang_app": true, "openssl_uprobes": 0, "go_tls_uprobes": 3}
+				],
+				"listens": [
+					{"addr": "0.0.0.0:8080", "pid": 10, "closed_at": "2024-01-02T03:05:05Z"},
+					{"addr": "0.0.0.0:8080", "pid": 20}
+				],
+				"connections": [
+					{"pid": 10, "fd": 5, "src": "10.0.0.1:40001", "dest": "10.0.0.3:6379", "actual_dest": "10.0.0.3:6379", "closed_at": "2024-01-02T03:05:05Z"},
+					{"pid": 20, "fd": 7, "src": "10.0.0.1:40000", "dest": "10.96.0.10:5432", "actual_dest": "10.0.0.2:5432"}
+				],
+				"log_parsers": ["journald", "stdout/stderr"]
+			}]`,
+		},
+		{
+			name:   "empty lists",
+			query:  "?pid=30",
+			status: http.StatusOK,
+			body: `[{
+				"id": "/system.slice/postgresql.service",
+				"cgroup_id": "/system.slice/postgresql.service",
+				"type": "systemd",
+				"started_at": "2024-01-02T03:04:05Z",
+				"restarts": 0,
+				"processes": [{"pid": 30, "started_at": "2024-01-02T03:04:05Z", "golang_app": false, "openssl_uprobes": 0, "go_tls_uprobes": 0}],
+				"listens": [],
+				"connections": [],
+				"log_parsers": []
+			}]`,
+		},
+		{name: "no match", query: "?pid=40", status: http.StatusOK, body: `[]`},
+		{name: "invalid pid", query: "?pid=abc", status: http.StatusBadRequest},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r.InventoryHandler(w, httptest.NewRequest(http.MethodGet, "/debug/containers"+tc.query, nil))
+			assert.Equal(t, tc.status, w.Code)
+			if tc.body != "" {
+				assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+				assert.JSONEq(t, tc.body, w.Body.String())
+			}
+		})
+	}
+}
@@ -14,7 +14,8 @@ type L7Metrics struct {
 	Latency  prometheus.Histogram
 }
 
-func (m *L7Metrics) observe(status, method string, duration time.Duration) {
+// observe records the request, the trace ID (if any) is attached to the latency histogram as an exemplar.
+func (m *L7Metrics) observe(status, method string, duration time.Duration, traceId string) {
 	if m.Requests != nil {
 		var err error
 		var c prometheus.Counter
@@ -30,13 +31,20 @@ func (m *L7Metrics) observe(status, method string, duration time.Duration) {
 		}
 	}
 	if m.Latency != nil && duration != 0 {
-		m.Latency.Observe(duration.Seconds())
+		if eo, ok := m.Latency.(prometheus.ExemplarObserver); ok && traceId != "" {
+			eo.ObserveWithExemplar(duration.Seconds(), prometheus.Labels{"trace_id": traceId})
+		} else {
+			m.Latency.Observe(duration.Seconds())
+		}
 	}
 }
 
 type L7Stats map[l7.Protocol]map[AddrPair]*L7Metrics // protocol -> dst:actual_dst -> metrics
 
-func (s L7Stats) get(protocol l7.Protocol, destination, actualDestination netaddr.IPPort) *L7Metrics {
+// overflowAddrPair accumulates the requests to the destinations that exceed the series limit.
+var overflowAddrPair = AddrPair{}
+
+func (s L7Stats) get(limit *seriesLimit, protocol l7.Protocol, destination, actualDestination netaddr.IPPort) *L7Metrics {
 	if protocol == l7.ProtocolHTTP2 {
 		protocol = l7.ProtocolHTTP
 	}
@@ -47,19 +55,25 @@ func (s L7Stats) get(protocol l7.Protocol, destination, actualDestination netadd
 	}
 	dest := AddrPair{src: destination, dst: actualDestination}
 	m := protoStats[dest]
+	if m == nil && !limit.acquire(protocol.String()+":"+destination.String()+":"+actualDestination.String()) {
+		dest = overflowAddrPair
+		m = protoStats[dest]
+	}
 	if m == nil {
 		m = &L7Metrics{}
 		protoStats[dest] = m
 		constLabels := map[string]string{"destination": destination.String(), "actual_destination": actualDestination.String()}
+		if dest == overflowAddrPair {
+			constLabels = map[string]string{"destination": overflowLabelValue, "actual_destination": overflowLabelValue}
+		}
 		labels := []string{"status"}
 		switch protocol {
 		case l7.ProtocolRabbitmq, l7.ProtocolNats:
 			labels = append(labels, "method")
 		default:
 			hOpts := L7Latency[protocol]
-			m.Latency = prometheus.NewHistogram(
-				prometheus.HistogramOpts{Name: hOpts.Name, Help: hOpts.Help, ConstLabels: constLabels},
-			)
+			hOpts.ConstLabels = constLabels
+			m.Latency = prometheus.NewHistogram(hOpts)
 		}
 		cOpts := L7Requests[protocol]
 		m.Requests = prometheus.NewCounterVec(
@@ -82,11 +96,12 @@ func (s L7Stats) collect(ch chan<- prometheus.Metric) {
 	}
 }
 
-func (s L7Stats) delete(dst netaddr.IPPort) {
+func (s L7Stats) delete(limit *seriesLimit, dst netaddr.IPPort) {
 	for _, protoStats := range s {
 		for d := range protoStats {
 			if d.src == dst {
 				delete(protoStats, d)
+				limit.release(1)
 			}
 		}
 	}
@@ -0,0 +1,46 @@
+package containers
+
+import (
+	"github.com/coroot/coroot-node-agent/ebpftracer"
+)
+
+const l7ShardBufferSize = 1000
+
+type l7Request struct {
+	container *Container
+	event     ebpftracer.Event
+}
+
+// L7 requests are parsed by the workers outside the events loop.
+// Requests are sharded by pid, so the requests of a connection are handled in order by the same worker.
+func (r *Registry) runL7Workers(n int) {
+	for i := 0; i < n; i++ {
+		ch := make(chan l7Request, l7ShardBufferSize)
+		r.l7Shards = append(r.l7Shards, ch)
+		r.l7Wg.Add(1)
+		go r.handleL7Requests(ch)
+	}
+}
+
+func (r *Registry) stopL7Workers() {
+	for _, ch := range r.l7Shards {
+		close(ch)
+	}
+	r.l7Wg.Wait()
+}
+
+func (r *Registry) dispatchL7Request(c *Container, e ebpftracer.Event) {
+	r.l7Shards[e.Pid%uint32(len(r.l7Shards))] <- l7Request{container: c, event: e}
+}
+
+func (r *Registry) handleL7Requests(ch <-chan l7Request) {
+	defer r.l7Wg.Done()
+	for req := range ch {
+		c, e := req.container, req.event
+		ip2fqdn := c.onL7Request(e.Pid, e.Fd, e.Timestamp, e.L7Request)
+		for ip, fqdn := range ip2fqdn {
+			r.ip2fqdn.set(ip, fqdn)
+		}
+		r.streams.publish(e, c)
+	}
+}
@@ -0,0 +1,188 @@
+package containers
+
+import (
+	"container/list"
+	"sync"
+
+	"github.com/coroot/coroot-node-agent/flags"
+	"inet.af/netaddr"
+)
+
+const (
+	overflowLabelValue = "__other__"
+
+	seriesKindDNS         = "dns"
+	seriesKindL7          = "l7"
+	seriesKindLogPatterns = "log_patterns"
+)
+
+// seriesBudget is shared by all containers and limits the total number of DNS, L7 and log pattern series.
+type seriesBudget struct {
+	lock    sync.Mutex
+	used    int
+	dropped map[string]int64
+}
+
+var globalSeriesBudget = &seriesBudget{dropped: map[string]int64{}}
+
+func (b *seriesBudget) acquire() bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if limit := flags.Get(flags.MaxSeries); limit > 0 && b.used >= limit {
+		return false
+	}
+	b.used++
+	return true
+}
+
+func (b *seriesBudget) release(n int) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.used -= n
+	if b.used < 0 {
+		b.used = 0
+	}
+}
+
+func (b *seriesBudget) drop(kind string) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.dropped[kind]++
+}
+
+func (b *seriesBudget) droppedSeries() map[string]int64 {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	res := make(map[string]int64, len(b.dropped))
+	for kind, v := range b.dropped {
+		res[kind] = v
+	}
+	return res
+}
+
+// maxOverflowedKeys bounds the memory used to count each key over the limit only once.
+const maxOverflowedKeys = 10000
+
+// seriesLimit tracks the number of series of a kind created by a container.
+// When either the container limit or the global budget is exhausted, the caller should account the data in the overflow bucket.
+// The keys that didn't fit are remembered, so they are reported as dropped once and don't hit the global budget on every request.
+type seriesLimit struct {
+	kind  string
+	limit *int
+
+	lock       sync.Mutex
+	count      int
+	overflowed map[string]struct{}
+}
+
+func newSeriesLimit(kind string, limit *int) *seriesLimit {
+	return &seriesLimit{kind: kind, limit: limit, overflowed: map[string]struct{}{}}
+}
+
+func (l *seriesLimit) acquire(key string) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if _, ok := l.overflowed[key]; ok {
+		return false
+	}
+	if limit := flags.Get(l.limit); limit > 0 && l.count >= limit || !globalSeriesBudget.acquire() {
+		globalSeriesBudget.drop(l.kind)
+		if len(l.overflowed) < maxOverflowedKeys {
+			l.overflowed[key] = struct{}{}
+		}
+		return false
+	}
+	l.count++
+	return true
+}
+
+func (l *seriesLimit) release(n int) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.releaseLocked(n)
+}
+
+func (l *seriesLimit) releaseAll() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.releaseLocked(l.count)
+}
+
+func (l *seriesLimit) releaseLocked(n int) {
+	if n > l.count {
+		n = l.count
+	}
+	if n <= 0 {
+		return
+	}
+	l.count -= n
+	globalSeriesBudget.release(n)
+	// the released series make room for the keys that didn't fit before
+	if len(l.overflowed) > 0 {
+		l.overflowed = map[string]struct{}{}
+	}
+}
+
+// fqdnCache maps IPs to the FQDNs they were resolved from.
+// It keeps at most MaxIp2FqdnEntries of them, evicting the least recently seen IPs.
+type fqdnCache struct {
+	lock      sync.Mutex
+	entries   map[netaddr.IP]*list.Element
+	lru       *list.List
+	evictions uint64
+}
+
+type fqdnEntry struct {
+	ip   netaddr.IP
+	fqdn string
+}
+
+func newFqdnCache() *fqdnCache {
+	return &fqdnCache{entries: map[netaddr.IP]*list.Element{}, lru: list.New()}
+}
+
+func (c *fqdnCache) set(ip netaddr.IP, fqdn string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if e := c.entries[ip]; e != nil {
+		e.Value.(*fqdnEntry).fqdn = fqdn
+		c.lru.MoveToFront(e)
+		return
+	}
+	if limit := flags.Get(flags.MaxIp2FqdnEntries); limit > 0 {
+		for c.lru.Len() >= limit {
+			oldest := c.lru.Back()
+			c.lru.Remove(oldest)
+			delete(c.entries, oldest.Value.(*fqdnEntry).ip)
+			c.evictions++
+		}
+	}
+	c.entries[ip] = c.lru.PushFront(&fqdnEntry{ip: ip, fqdn: fqdn})
+}
+
+// retain deletes the IPs that are no longer active.
+func (c *fqdnCache) retain(active map[netaddr.IP]struct{}) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for ip, e := range c.entries {
+		if _, ok := active[ip]; !ok {
+			c.lru.Remove(e)
+			delete(c.entries, ip)
+		}
+	}
+}
+
+func (c *fqdnCache) forEach(f func(ip netaddr.IP, fqdn string)) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for ip, e := range c.entries {
+		f(ip, e.Value.(*fqdnEntry).fqdn)
+	}
+}
+
+// evicted returns the number of mappings evicted because of the limit.
+func (c *fqdnCache) evicted() uint64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.evictions
+}
@@ -0,0 +1,105 @@
+package containers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/coroot/coroot-node-agent/ebpftracer/l7"
+	"github.com/coroot/coroot-node-agent/flags"
+	"github.com/stretchr/testify/assert"
+	"inet.af/netaddr"
+)
+
+func TestSeriesLimit(t *testing.T) {
+	limit := 2
+	l := newSeriesLimit(seriesKindDNS, &limit)
+	dropped := func() int64 { return globalSeriesBudget.droppedSeries()[seriesKindDNS] }
+	before := dropped()
+
+	assert.True(t, l.acquire("a"))
+	assert.True(t, l.acquire("b"))
+	assert.False(t, l.acquire("c"))
+	assert.False(t, l.acquire("c"))
+	assert.False(t, l.acquire("d"))
+	assert.Equal(t, before+2, dropped()) // each key is counted once
+
+	l.release(1)
+	assert.True(t, l.acquire("c"))
+	l.releaseAll()
+	assert.Equal(t, 0, l.count)
+}
+
+func TestFqdnCache(t *testing.T) {
+	defer func(v int) { *flags.MaxIp2FqdnEntries = v }(*flags.MaxIp2FqdnEntries)
+	*flags.MaxIp2FqdnEntries = 2
+
+	ip1, ip2, ip3 := netaddr.MustParseIP("10.0.0.1"), netaddr.MustParseIP("10.0.0.2"), netaddr.MustParseIP("10.0.0.3")
+	c := newFqdnCache()
+	get := func() map[netaddr.IP]string {
+		res := map[netaddr.IP]string{}
+		c.forEach(func(ip netaddr.IP, fqdn string) {
+			res[ip] = fqdn
+		})
+		return res
+	}
+	c.set(ip1, "a.example.com")
+	c.set(ip2, "b.example.com")
+	c.set(ip1, "a.example.com") // ip2 is now the least recently seen
+	c.set(ip3, "c.example.com")
+	assert.Equal(t, map[netaddr.IP]string{ip1: "a.example.com", ip3: "c.example.com"}, get())
+	assert.Equal(t, uint64(1), c.evicted())
+	assert.Zero(t, globalSeriesBudget.droppedSeries()["ip2fqdn"], "evictions aren't dropped series")
+
+	c.retain(map[netaddr.IP]struct{}{ip3: {}})
+	assert.Equal(t, map[netaddr.IP]string{ip3: "c.example.com"}, get())
+	assert.Equal(t, uint64(1), c.evicted(), "inactive IPs aren't evictions")
+}
+
+func TestClosedContainerL7Requests(t *testing.T) {
+	limit := 10
+	c := &Container{
+		connectionsByPidFd: map[PidFd]*ActiveConnection{
+			{Pid: 1, Fd: 3}: {Dest: netaddr.MustParseIPPort("10.96.0.10:6379"), ActualDest: netaddr.MustParseIPPort("10.0.0.2:6379")},
+			{Pid: 1, Fd: 4}: {Dest: netaddr.MustParseIPPort("10.96.0.11:6379"), ActualDest: netaddr.MustParseIPPort("10.0.0.3:6379")},
+		},
+		l7Stats:          L7Stats{},
+		l7Limit:          newSeriesLimit(seriesKindL7, &limit),
+		dnsLimit:         newSeriesLimit(seriesKindDNS, &limit),
+		logPatternsLimit: newSeriesLimit(seriesKindLogPatterns, &limit),
+		done:             make(chan struct{}),
+	}
+	r := &l7.RequestData{Protocol: l7.ProtocolRedis, Status: l7.StatusOk}
+	c.onL7Request(1, 3, 0, r)
+	assert.Equal(t, 1, c.l7Limit.count)
+
+	c.Close()
+	assert.Equal(t, 0, c.l7Limit.count)
+	c.onL7Request(1, 4, 0, r) // a request queued before the container was closed
+	assert.Equal(t, 0, c.l7Limit.count)
+}
+
+func TestClosedContainerNetNamespaces(t *testing.T) {
+	limit := 10
+	newContainer := func() *Container {
+		return &Container{
+			l7Limit:          newSeriesLimit(seriesKindL7, &limit),
+			dnsLimit:         newSeriesLimit(seriesKindDNS, &limit),
+			logPatternsLimit: newSeriesLimit(seriesKindLogPatterns, &limit),
+			done:             make(chan struct{}),
+		}
+	}
+	c1, c2 := newContainer(), newContainer()
+	setNetNamespaces(c1, map[string]uint32{"4026532001": 100})
+	setNetNamespaces(c2, map[string]uint32{"4026532001": 200})
+	assert.Len(t, netNsUsers.byNs["4026532001"], 2)
+
+	c2.Close()
+	assert.Equal(t, map[string]uint32{"4026532001": 100}, netNsPids())
+	c2.gc(time.Now()) // a gc that raced with Close must not touch the released namespaces
+	assert.Equal(t, map[string]uint32{"4026532001": 200}, c2.netNamespaces)
+
+	c1.Close()
+	netNsUsers.lock.Lock()
+	assert.Empty(t, netNsUsers.byNs)
+	netNsUsers.lock.Unlock()
+}
@@ -1,6 +1,11 @@
 package containers
 
 import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
 	"github.com/coroot/coroot-node-agent/ebpftracer/l7"
 	"github.com/prometheus/client_golang/prometheus"
 )
@@ -9,15 +14,32 @@ var metrics = struct {
 	ContainerInfo *prometheus.Desc
 	Restarts      *prometheus.Desc
 
-	CPULimit      *prometheus.Desc
-	CPUUsage      *prometheus.Desc
-	CPUDelay      *prometheus.Desc
-	ThrottledTime *prometheus.Desc
-
-	MemoryLimit *prometheus.Desc
-	MemoryRss   *prometheus.Desc
-	MemoryCache *prometheus.Desc
-	OOMKills    *prometheus.Desc
+	CPULimit            *prometheus.Desc
+	CPUUsage            *prometheus.Desc
+	CPUUser             *prometheus.Desc
+	CPUSystem           *prometheus.Desc
+	CPUDelay            *prometheus.Desc
+	ThrottledTime       *prometheus.Desc
+	CPUPeriods          *prometheus.Desc
+	CPUThrottledPeriods *prometheus.Desc
+	CPUShares           *prometheus.Desc
+	CPUWeight           *prometheus.Desc
+	CPUSet              *prometheus.Desc
+	CPUPressure         *prometheus.Desc
+
+	MemoryLimit        *prometheus.Desc
+	MemoryHigh         *prometheus.Desc
+	MemoryRss          *prometheus.Desc
+	MemoryCache        *prometheus.Desc
+	MemoryActiveFile   *prometheus.Desc
+	MemoryInactiveFile *prometheus.Desc
+	MemoryWorkingSet   *prometheus.Desc
+	MemorySwap         *prometheus.Desc
+	MemorySwapLimit    *prometheus.Desc
+	MemoryPageFaults   *prometheus.Desc
+	MemoryEvents       *prometheus.Desc
+	MemoryPressure     *prometheus.Desc
+	OOMKills           *prometheus.Desc
 
 	DiskDelay      *prometheus.Desc
 	DiskSize       *prometheus.Desc
@@ -27,6 +49,7 @@ var metrics = struct {
 	DiskReadBytes  *prometheus.Desc
 	DiskWriteOps   *prometheus.Desc
 	DiskWriteBytes *prometheus.Desc
+	IOPressure     *prometheus.Desc
 
 	NetListenInfo         *prometheus.Desc
 	NetConnectsSuccessful *prometheus.Desc
@@ -34,6 +57,15 @@ var metrics = struct {
 	NetConnectionsActive  *prometheus.Desc
 	NetRetransmits        *prometheus.Desc
 	NetLatency            *prometheus.Desc
+	NetNamespace          *prometheus.Desc
+	NetRxBytes            *prometheus.Desc
+	NetRxPackets          *prometheus.Desc
+	NetRxErrors           *prometheus.Desc
+	NetRxDrops            *prometheus.Desc
+	NetTxBytes            *prometheus.Desc
+	NetTxPackets          *prometheus.Desc
+	NetTxErrors           *prometheus.Desc
+	NetTxDrops            *prometheus.Desc
 
 	LogMessages *prometheus.Desc
 
@@ -46,20 +78,40 @@ var metrics = struct {
 	JvmSafepointTime     *prometheus.Desc
 	JvmSafepointSyncTime *prometheus.Desc
 	Ip2Fqdn              *prometheus.Desc
+	Ip2FqdnEvictions     *prometheus.Desc
+	DroppedSeries        *prometheus.Desc
+	TracePidErrors       *prometheus.Desc
 }{
 	ContainerInfo: metric("container_info", "Meta information about the container", "image", "systemd_triggered_by"),
 
 	Restarts: metric("container_restarts_total", "Number of times the container was restarted"),
 
-	CPULimit:      metric("container_resources_cpu_limit_cores", "CPU limit of the container"),
-	CPUUsage:      metric("container_resources_cpu_usage_seconds_total", "Total CPU time consumed by the container"),
-	CPUDelay:      metric("container_resources_cpu_delay_seconds_total", "Total time duration processes of the container have been waiting for a CPU (while being runnable)"),
-	ThrottledTime: metric("container_resources_cpu_throttled_seconds_total", "Total time duration the container has been throttled"),
-
-	MemoryLimit: metric("container_resources_memory_limit_bytes", "Memory limit of the container"),
-	MemoryRss:   metric("container_resources_memory_rss_bytes", "Amount of physical memory used by the container (doesn't include page cache)"),
-	MemoryCache: metric("container_resources_memory_cache_bytes", "Amount of page cache memory allocated by the container"),
-	OOMKills:    metric("container_oom_kills_total", "Total number of times the container was terminated by the OOM killer"),
+	CPULimit:            metric("container_resources_cpu_limit_cores", "CPU limit of the container"),
+	CPUUsage:            metric("container_resources_cpu_usage_seconds_total", "Total CPU time consumed by the container"),
+	CPUUser:             metric("container_resources_cpu_user_seconds_total", "Total CPU time consumed by the container in user mode"),
+	CPUSystem:           metric("container_resources_cpu_system_seconds_total", "Total CPU time consumed by the container in kernel mode"),
+	CPUDelay:            metric("container_resources_cpu_delay_seconds_total", "Total time duration processes of the container have been waiting for a CPU (while being runnable)"),
+	ThrottledTime:       metric("container_resources_cpu_throttled_seconds_total", "Total time duration the container has been throttled"),
+	CPUPeriods:          metric("container_resources_cpu_periods_total", "Total number of CFS enforcement periods elapsed while the container was runnable"),
+	CPUThrottledPeriods: metric("container_resources_cpu_throttled_periods_total", "Total number of CFS enforcement periods in which the container has been throttled"),
+	CPUShares:           metric("container_resources_cpu_shares", "Relative CPU weight of the container (cpu.shares, cgroup v1 only)"),
+	CPUWeight:           metric("container_resources_cpu_weight", "Relative CPU weight of the container (cpu.weight, cgroup v2 only)"),
+	CPUSet:              metric("container_resources_cpuset_cpus", "Number of CPUs the container is allowed to run on", "cpus"),
+	CPUPressure:         metric("container_resources_cpu_pressure_seconds_total", "Total time duration processes of the container have been stalled waiting for a CPU (PSI, cgroup v2 only)", "kind"),
+
+	MemoryLimit:        metric("container_resources_memory_limit_bytes", "Memory limit of the container"),
+	MemoryHigh:         metric("container_resources_memory_high_bytes", "Memory usage throttle limit of the container (memory.high, cgroup v2 only)"),
+	MemoryRss:          metric("container_resources_memory_rss_bytes", "Amount of physical memory used by the container (doesn't include page cache)"),
+	MemoryCache:        metric("container_resources_memory_cache_bytes", "Amount of page cache memory allocated by the container"),
+	MemoryActiveFile:   metric("container_resources_memory_active_file_bytes", "Amount of page cache memory on the active LRU list"),
+	MemoryInactiveFile: metric("container_resources_memory_inactive_file_bytes", "Amount of page cache memory on the inactive LRU list (can be reclaimed first)"),
+	MemoryWorkingSet:   metric("container_resources_memory_working_set_bytes", "Memory usage of the container excluding the inactive page cache (the value Kubernetes uses for evictions)"),
+	MemorySwap:         metric("container_resources_memory_swap_bytes", "Amount of swap used by the container"),
+	MemorySwapLimit:    metric("container_resources_memory_swap_limit_bytes", "Swap limit of the container"),
+	MemoryPageFaults:   metric("container_resources_memory_page_faults_total", "Total number of page faults incurred by the container", "kind"),
+	MemoryEvents:       metric("container_resources_memory_events_total", "Total number of memory events of the container (memory.events on cgroup v2, memory.failcnt and oom_kill on cgroup v1)", "event"),
+	MemoryPressure:     metric("container_resources_memory_pressure_seconds_total", "Total time duration processes of the container have been stalled waiting for memory (PSI, cgroup v2 only)", "kind"),
+	OOMKills:           metric("container_oom_kills_total", "Total number of times the container was terminated by the OOM killer"),
 
 	DiskDelay:      metric("container_resources_disk_delay_seconds_total", "Total time duration processes of the container have been waiting fot I/Os to complete"),
 	DiskSize:       metric("container_resources_disk_size_bytes", "Total capacity of the volume", "mount_point", "device", "volume"),
@@ -69,6 +121,7 @@ var metrics = struct {
 	DiskReadBytes:  metric("container_resources_disk_read_bytes_total", "Total number of bytes read from the disk by the container", "mount_point", "device", "volume"),
 	DiskWriteOps:   metric("container_resources_disk_writes_total", "Total number of writes completed successfully by the container", "mount_point", "device", "volume"),
 	DiskWriteBytes: metric("container_resources_disk_written_bytes_total", "Total number of bytes written to the disk by the container", "mount_point", "device", "volume"),
+	IOPressure:     metric("container_resources_io_pressure_seconds_total", "Total time duration processes of the container have been stalled waiting for I/Os (PSI, cgroup v2 only)", "kind"),
 
 	NetListenInfo:         metric("container_net_tcp_listen_info", "Listen address of the container", "listen_addr", "proxy"),
 	NetConnectsSuccessful: metric("container_net_tcp_successful_connects_total", "Total number of successful TCP connects", "destination", "actual_destination"),
@@ -76,6 +129,15 @@ var metrics = struct {
 	NetConnectionsActive:  metric("container_net_tcp_active_connections", "Number of active outbound connections used by the container", "destination", "actual_destination"),
 	NetRetransmits:        metric("container_net_tcp_retransmits_total", "Total number of retransmitted TCP segments", "destination", "actual_destination"),
 	NetLatency:            metric("container_net_latency_seconds", "Round-trip time between the container and a remote IP", "destination_ip"),
+	NetNamespace:          metric("container_net_namespace", "Network namespace of the container other than the host one, its traffic is reported by container_net_* metrics with the same netns", "netns"),
+	NetRxBytes:            metric("container_net_rx_bytes_total", "Total number of bytes received by the network interfaces of the network namespace", "netns"),
+	NetRxPackets:          metric("container_net_rx_packets_total", "Total number of packets received by the network interfaces of the network namespace", "netns"),
+	NetRxErrors:           metric("container_net_rx_errors_total", "Total number of receive errors on the network interfaces of the network namespace", "netns"),
+	NetRxDrops:            metric("container_net_rx_drops_total", "Total number of received packets dropped by the network interfaces of the network namespace", "netns"),
+	NetTxBytes:            metric("container_net_tx_bytes_total", "Total number of bytes transmitted by the network interfaces of the network namespace", "netns"),
+	NetTxPackets:          metric("container_net_tx_packets_total", "Total number of packets transmitted by the network interfaces of the network namespace", "netns"),
+	NetTxErrors:           metric("container_net_tx_errors_total", "Total number of transmit errors on the network interfaces of the network namespace", "netns"),
+	NetTxDrops:            metric("container_net_tx_drops_total", "Total number of transmitted packets dropped by the network interfaces of the network namespace", "netns"),
 
 	LogMessages: metric("container_log_messages_total", "Number of messages grouped by the automatically extracted repeated pattern", "source", "level", "pattern_hash", "sample"),
 
@@ -88,6 +150,9 @@ var metrics = struct {
 	JvmSafepointTime:     metric("container_jvm_safepoint_time_seconds", "Time the application has been stopped for safepoint operations in seconds", "jvm"),
 	JvmSafepointSyncTime: metric("container_jvm_safepoint_sync_time_seconds", "Time spent getting to safepoints in seconds", "jvm"),
 	Ip2Fqdn:              metric("ip_to_fqdn", "Mapping IP addresses to FQDNs based on DNS requests initiated by containers", "ip", "fqdn"),
+	Ip2FqdnEvictions:     metric("node_agent_ip_to_fqdn_evictions_total", "Number of IP to FQDN mappings evicted from the cache because of --max-ip2fqdn-entries"),
+	DroppedSeries:        metric("node_agent_dropped_series_total", "Number of times a new series was not created because of the configured limits", "kind"),
+	TracePidErrors:       metric("node_agent_trace_pid_errors_total", "Number of processes of the tracked containers that couldn't be added to the eBPF map of the traced processes, their L7 requests aren't traced"),
 }
 
 var (
@@ -119,6 +184,54 @@ var (
 	}
 )
 
+const (
+	nativeHistogramBucketFactor     = 1.1
+	nativeHistogramMaxBucketNumber  = 160
+	nativeHistogramMinResetDuration = time.Hour
+)
+
+// initL7LatencyOpts applies the bucket overrides (`<protocol>=<bucket>,<bucket>,...`) to L7Latency
+// and enables native histograms if requested.
+func initL7LatencyOpts(buckets []string, native bool) error {
+	protocols := map[string]l7.Protocol{}
+	for p := range L7Latency {
+		protocols[strings.ToLower(p.String())] = p
+	}
+	for _, spec := range buckets {
+		name, values, ok := strings.Cut(spec, "=")
+		if !ok {
+			return fmt.Errorf("invalid L7 latency buckets %q, expected <protocol>=<bucket>,<bucket>,...", spec)
+		}
+		protocol, ok := protocols[strings.ToLower(strings.TrimSpace(name))]
+		if !ok {
+			return fmt.Errorf("invalid L7 latency buckets %q: unknown protocol %q", spec, name)
+		}
+		var bs []float64
+		for _, v := range strings.Split(values, ",") {
+			b, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+			if err != nil {
+				return fmt.Errorf("invalid L7 latency buckets %q: %w", spec, err)
+			}
+			if len(bs) > 0 && b <= bs[len(bs)-1] {
+				return fmt.Errorf("invalid L7 latency buckets %q: buckets must be in increasing order", spec)
+			}
+			bs = append(bs, b)
+		}
+		opts := L7Latency[protocol]
+		opts.Buckets = bs
+		L7Latency[protocol] = opts
+	}
+	if native {
+		for p, opts := range L7Latency {
+			opts.NativeHistogramBucketFactor = nativeHistogramBucketFactor
+			opts.NativeHistogramMaxBucketNumber = nativeHistogramMaxBucketNumber
+			opts.NativeHistogramMinResetDuration = nativeHistogramMinResetDuration
+			L7Latency[p] = opts
+		}
+	}
+	return nil
+}
+
 func metric(name, help string, labels ...string) *prometheus.Desc {
 	return prometheus.NewDesc(name, help, labels, nil)
 }
@@ -0,0 +1,98 @@
+package containers
+
+import (
+	"sync"
+
+	"github.com/coroot/coroot-node-agent/common"
+	"github.com/coroot/coroot-node-agent/proc"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// The containers of a Kubernetes pod (or of a docker container started with --network=container:<id>) share a network namespace,
+// so its traffic is reported once per namespace by the registry with the stable netns label,
+// and each container reports the namespaces it belongs to with container_net_namespace.
+var netNsUsers = struct {
+	lock sync.Mutex
+	byNs map[string]map[*Container]uint32 // the pid of the container to read the namespace's /proc/<pid>/net from
+}{byNs: map[string]map[*Container]uint32{}}
+
+func setNetNamespaces(c *Container, namespaces map[string]uint32) {
+	netNsUsers.lock.Lock()
+	defer netNsUsers.lock.Unlock()
+	for ns := range c.netNamespaces {
+		if _, ok := namespaces[ns]; !ok {
+			releaseNetNs(c, ns)
+		}
+	}
+	for ns, pid := range namespaces {
+		users := netNsUsers.byNs[ns]
+		if users == nil {
+			users = map[*Container]uint32{}
+			netNsUsers.byNs[ns] = users
+		}
+		users[c] = pid
+	}
+	c.netNamespaces = namespaces
+}
+
+func releaseNetNamespaces(c *Container) {
+	netNsUsers.lock.Lock()
+	defer netNsUsers.lock.Unlock()
+	for ns := range c.netNamespaces {
+		releaseNetNs(c, ns)
+	}
+}
+
+func releaseNetNs(c *Container, ns string) {
+	users := netNsUsers.byNs[ns]
+	delete(users, c)
+	if len(users) == 0 {
+		delete(netNsUsers.byNs, ns)
+	}
+}
+
+// netNsPids returns a pid of any container in each of the tracked network namespaces.
+func netNsPids() map[string]uint32 {
+	netNsUsers.lock.Lock()
+	defer netNsUsers.lock.Unlock()
+	res := make(map[string]uint32, len(netNsUsers.byNs))
+	for ns, users := range netNsUsers.byNs {
+		for _, pid := range users {
+			res[ns] = pid
+			break
+		}
+	}
+	return res
+}
+
+// collectNetDev reports the interface counters of each tracked network namespace except the host one.
+// The counters belong to the namespace, so they don't depend on which containers are in it at the moment.
+func collectNetDev(ch chan<- prometheus.Metric) {
+	for ns, pid := range netNsPids() {
+		stats, err := common.Snapshot("netdev:"+ns, func() ([]proc.NetDevStat, error) {
+			return proc.GetNetDev(pid)
+		})
+		if err != nil {
+			continue
+		}
+		var total proc.NetDevStat
+		for _, s := range stats {
+			total.RxBytes += s.RxBytes
+			total.RxPackets += s.RxPackets
+			total.RxErrors += s.RxErrors
+			total.RxDrops += s.RxDrops
+			total.TxBytes += s.TxBytes
+			total.TxPackets += s.TxPackets
+			total.TxErrors += s.TxErrors
+			total.TxDrops += s.TxDrops
+		}
+		ch <- counter(metrics.NetRxBytes, float64(total.RxBytes), ns)
+		ch <- counter(metrics.NetRxPackets, float64(total.RxPackets), ns)
+		ch <- counter(metrics.NetRxErrors, float64(total.RxErrors), ns)
+		ch <- counter(metrics.NetRxDrops, float64(total.RxDrops), ns)
+		ch <- counter(metrics.NetTxBytes, float64(total.TxBytes), ns)
+		ch <- counter(metrics.NetTxPackets, float64(total.TxPackets), ns)
+		ch <- counter(metrics.NetTxErrors, float64(total.TxErrors), ns)
+		ch <- counter(metrics.NetTxDrops, float64(total.TxDrops), ns)
+	}
+}
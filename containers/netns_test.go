@@ -0,0 +1,45 @@
+package containers
+
+import (
+	"testing"
+
+	"github.com/coroot/coroot-node-agent/proc"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCollectNetDev(t *testing.T) {
+	proc.SetRoot("../proc/fixtures")
+	defer proc.SetRoot("/proc")
+
+	app, sidecar := &Container{}, &Container{}
+	setNetNamespaces(app, map[string]uint32{"4026532001": 123})
+	defer releaseNetNamespaces(app)
+
+	collect := func() map[string]float64 {
+		ch := make(chan prometheus.Metric, 100)
+		collectNetDev(ch)
+		close(ch)
+		res := map[string]float64{}
+		for m := range ch {
+			var pb dto.Metric
+			require.NoError(t, m.Write(&pb))
+			require.Len(t, pb.Label, 1)
+			assert.Equal(t, "netns", pb.Label[0].GetName())
+			res[m.Desc().String()+pb.Label[0].GetValue()] = pb.GetCounter().GetValue()
+		}
+		return res
+	}
+	before := collect()
+	assert.Len(t, before, 8)
+	assert.Equal(t, float64(91855162+4096), before[metrics.NetRxBytes.String()+"4026532001"])
+	assert.Equal(t, float64(2), before[metrics.NetTxDrops.String()+"4026532001"])
+
+	// the traffic is reported once and with the same labels, no matter how many containers share the namespace
+	setNetNamespaces(sidecar, map[string]uint32{"4026532001": 123})
+	assert.Equal(t, before, collect())
+	releaseNetNamespaces(sidecar)
+	assert.Equal(t, before, collect())
+}
@@ -27,6 +27,8 @@ type Process struct {
 	uprobes               []link.Link
 	goTlsUprobesChecked   bool
 	openSslUprobesChecked bool
+	openSslUprobes        int
+	goTlsUprobes          int
 }
 
 func NewProcess(pid uint32, stats *taskstats.Stats) *Process {
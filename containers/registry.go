@@ -5,14 +5,18 @@ import (
 	"fmt"
 	"os"
 	"regexp"
+	"runtime"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/cilium/ebpf/link"
 	"github.com/coroot/coroot-node-agent/cgroup"
 	"github.com/coroot/coroot-node-agent/common"
 	"github.com/coroot/coroot-node-agent/ebpftracer"
 	"github.com/coroot/coroot-node-agent/flags"
+	"github.com/coroot/coroot-node-agent/health"
 	"github.com/coroot/coroot-node-agent/proc"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/vishvananda/netns"
@@ -27,30 +31,64 @@ var (
 	containerIdRegexp = regexp.MustCompile(`[a-z0-9]{64}`)
 )
 
+const eventsLoopHeartbeat = "events_loop"
+
 type ProcessInfo struct {
 	Pid         uint32
 	ContainerId ContainerID
 	StartedAt   time.Time
 }
 
+// eventTracer is implemented by ebpftracer.Tracer and replaced with a fake in tests.
+type eventTracer interface {
+	Run(events chan<- ebpftracer.Event) error
+	Close()
+	TracePid(pid uint32) error
+	UntracePid(pid uint32)
+	AttachOpenSslUprobes(pid uint32) []link.Link
+	AttachGoTlsUprobes(pid uint32) ([]link.Link, bool)
+}
+
 type Registry struct {
 	reg prometheus.Registerer
 
-	tracer *ebpftracer.Tracer
-	events chan ebpftracer.Event
+	tracer         eventTracer
+	tracePidErrors atomic.Uint64
+	events         chan ebpftracer.Event
+	done           chan struct{}
+
+	inventoryRequests chan inventoryRequest
+	streams           eventStreams
+
+	l7Shards []chan l7Request
+	l7Wg     sync.WaitGroup
 
 	hostConntrack *Conntrack
 
 	containersById       map[ContainerID]*Container
 	containersByCgroupId map[string]*Container
 	containersByPid      map[uint32]*Container
-	ip2fqdn              map[netaddr.IP]string
-	ip2fqdnLock          sync.Mutex
+	containersByAddrPair map[AddrPair]*Container
+	ip2fqdn              *fqdnCache
+
+	filter          *containerFilter
+	excludedCgroups map[string]*cgroup.Cgroup
 
 	processInfoCh chan<- ProcessInfo
 }
 
 func NewRegistry(reg prometheus.Registerer, kernelVersion string, processInfoCh chan<- ProcessInfo) (*Registry, error) {
+	if err := initL7LatencyOpts(*flags.L7LatencyBuckets, *flags.NativeHistograms); err != nil {
+		return nil, err
+	}
+	filter, err := newContainerFilter(*flags.ContainerInclude, *flags.ContainerExclude)
+	if err != nil {
+		return nil, err
+	}
+	l7Config, err := ebpftracer.NewL7Config(*flags.DisableL7Protocols, *flags.L7PayloadSizes)
+	if err != nil {
+		return nil, err
+	}
 	ns, err := proc.GetSelfNetNs()
 	if err != nil {
 		return nil, err
@@ -63,53 +101,74 @@ func NewRegistry(reg prometheus.Registerer, kernelVersion string, processInfoCh
 	defer hostNetNs.Close()
 	hostNetNsId = hostNetNs.UniqueId()
 
+	health.Require("cgroup", "ebpf", "initial_scan")
 	err = proc.ExecuteInNetNs(hostNetNs, selfNetNs, func() error {
 		if err := TaskstatsInit(); err != nil {
 			return err
 		}
 		return nil
 	})
+	health.Report("taskstats", err)
 	if err != nil {
-		return nil, err
+		klog.Warningln("taskstats is unavailable, CPU and disk delays are not collected:", err)
 	}
-	if err := cgroup.Init(); err != nil {
+	err = cgroup.Init()
+	health.Report("cgroup", err)
+	if err != nil {
 		return nil, err
 	}
-	if err := DockerdInit(); err != nil {
+	err = DockerdInit()
+	health.Report("dockerd", err)
+	if err != nil {
 		klog.Warningln(err)
 	}
-	if err := ContainerdInit(); err != nil {
+	err = ContainerdInit()
+	health.Report("containerd", err)
+	if err != nil {
 		klog.Warningln(err)
 	}
-	if err := CrioInit(); err != nil {
+	err = CrioInit()
+	health.Report("crio", err)
+	if err != nil {
 		klog.Warningln(err)
 	}
-	if err := JournaldInit(); err != nil {
+	err = JournaldInit()
+	health.Report("journald", err)
+	if err != nil {
 		klog.Warningln(err)
 	}
 	ct, err := NewConntrack(hostNetNs)
+	health.Report("conntrack", err)
 	if err != nil {
-		return nil, err
+		klog.Warningln("conntrack is unavailable, the actual destinations of NAT'ed connections are unknown:", err)
 	}
 
 	r := &Registry{
 		reg:    reg,
 		events: make(chan ebpftracer.Event, 10000),
+		done:   make(chan struct{}),
+
+		inventoryRequests: make(chan inventoryRequest),
 
 		hostConntrack: ct,
 
 		containersById:       map[ContainerID]*Container{},
 		containersByCgroupId: map[string]*Container{},
 		containersByPid:      map[uint32]*Container{},
-		ip2fqdn:              map[netaddr.IP]string{},
+		containersByAddrPair: map[AddrPair]*Container{},
+		ip2fqdn:              newFqdnCache(),
+
+		filter:          filter,
+		excludedCgroups: map[string]*cgroup.Cgroup{},
 
 		processInfoCh: processInfoCh,
 
-		tracer: ebpftracer.NewTracer(kernelVersion, *flags.DisableL7Tracing),
+		tracer: ebpftracer.NewTracer(kernelVersion, *flags.DisableEbpf, *flags.DisableL7Tracing, l7Config),
 	}
 	if err = reg.Register(r); err != nil {
 		return nil, err
 	}
+	r.runL7Workers(runtime.NumCPU())
 	go r.handleEvents(r.events)
 	if err = r.tracer.Run(r.events); err != nil {
 		close(r.events)
@@ -121,38 +180,63 @@ func NewRegistry(reg prometheus.Registerer, kernelVersion string, processInfoCh
 
 func (r *Registry) Describe(ch chan<- *prometheus.Desc) {
 	ch <- metrics.Ip2Fqdn
+	ch <- metrics.Ip2FqdnEvictions
+	ch <- metrics.DroppedSeries
+	ch <- metrics.TracePidErrors
+	ch <- metrics.NetRxBytes
+	ch <- metrics.NetRxPackets
+	ch <- metrics.NetRxErrors
+	ch <- metrics.NetRxDrops
+	ch <- metrics.NetTxBytes
+	ch <- metrics.NetTxPackets
+	ch <- metrics.NetTxErrors
+	ch <- metrics.NetTxDrops
 }
 
 func (r *Registry) Collect(ch chan<- prometheus.Metric) {
-	r.ip2fqdnLock.Lock()
-	defer r.ip2fqdnLock.Unlock()
-	for ip, fqdn := range r.ip2fqdn {
+	r.ip2fqdn.forEach(func(ip netaddr.IP, fqdn string) {
 		ch <- gauge(metrics.Ip2Fqdn, 1, ip.String(), fqdn)
+	})
+	ch <- counter(metrics.Ip2FqdnEvictions, float64(r.ip2fqdn.evicted()))
+	for kind, v := range globalSeriesBudget.droppedSeries() {
+		ch <- counter(metrics.DroppedSeries, float64(v), kind)
 	}
+	ch <- counter(metrics.TracePidErrors, float64(r.tracePidErrors.Load()))
+	collectNetDev(ch)
 }
 
+// Close detaches the eBPF programs and waits until the remaining events are handled.
 func (r *Registry) Close() {
 	r.tracer.Close()
 	close(r.events)
+	<-r.done
 }
 
 func (r *Registry) handleEvents(ch <-chan ebpftracer.Event) {
+	defer close(r.done)
 	gcTicker := time.NewTicker(gcInterval)
 	defer gcTicker.Stop()
+	heartbeatTicker := time.NewTicker(health.HeartbeatInterval)
+	defer heartbeatTicker.Stop()
+	health.Heartbeat(eventsLoopHeartbeat)
 	for {
 		select {
+		case <-heartbeatTicker.C:
+			health.Heartbeat(eventsLoopHeartbeat)
+		case req := <-r.inventoryRequests:
+			req.res <- r.inventory(req.filter)
 		case now := <-gcTicker.C:
 			for pid, c := range r.containersByPid {
 				cg, err := proc.ReadCgroup(pid)
 				if err != nil {
-					delete(r.containersByPid, pid)
+					r.deletePid(pid)
 					if c != nil {
 						c.onProcessExit(pid, false)
 					}
 					continue
 				}
 				if c != nil && cg.Id != c.cgroup.Id {
-					delete(r.containersByPid, pid)
+					r.deletePid(pid)
 					c.onProcessExit(pid, false)
 				}
 			}
@@ -172,7 +256,7 @@ func (r *Registry) handleEvents(ch <-chan ebpftracer.Event) {
 				}
 				for pid, cc := range r.containersByPid {
 					if cc == c {
-						delete(r.containersByPid, pid)
+						r.deletePid(pid)
 					}
 				}
 				if ok := prometheus.WrapRegistererWith(prometheus.Labels{"container_id": string(id)}, r.reg).Unregister(c); !ok {
@@ -181,31 +265,39 @@ func (r *Registry) handleEvents(ch <-chan ebpftracer.Event) {
 				delete(r.containersById, id)
 				c.Close()
 			}
-			r.ip2fqdnLock.Lock()
-			for ip := range r.ip2fqdn {
-				if _, ok := activeIPs[ip]; !ok {
-					delete(r.ip2fqdn, ip)
+			for srcDst, c := range r.containersByAddrPair {
+				if r.containersById[c.id] != c || !c.hasConnection(srcDst) {
+					delete(r.containersByAddrPair, srcDst)
+				}
+			}
+			for id, cg := range r.excludedCgroups {
+				if cg.CreatedAt().IsZero() {
+					delete(r.excludedCgroups, id)
 				}
 			}
-			r.ip2fqdnLock.Unlock()
+			r.ip2fqdn.retain(activeIPs)
 		case e, more := <-ch:
 			if !more {
+				r.stopL7Workers()
+				health.RemoveHeartbeat(eventsLoopHeartbeat)
 				return
 			}
+			var container *Container
 			switch e.Type {
 			case ebpftracer.EventTypeProcessStart:
 				c, seen := r.containersByPid[e.Pid]
 				switch { // possible pids wraparound + missed `process-exit` event
 				case c == nil && seen: // ignored
-					delete(r.containersByPid, e.Pid)
+					r.deletePid(e.Pid)
 				case c != nil: // revalidating by cgroup
 					cg, err := proc.ReadCgroup(e.Pid)
 					if err != nil || cg.Id != c.cgroup.Id {
-						delete(r.containersByPid, e.Pid)
+						r.deletePid(e.Pid)
 						c.onProcessExit(e.Pid, false)
 					}
 				}
 				if c := r.getOrCreateContainer(e.Pid); c != nil {
+					container = c
 					p := c.onProcessStart(e.Pid)
 					if r.processInfoCh != nil && p != nil {
 						r.processInfoCh <- ProcessInfo{Pid: p.Pid, ContainerId: c.id, StartedAt: p.StartedAt}
@@ -213,51 +305,63 @@ func (r *Registry) handleEvents(ch <-chan ebpftracer.Event) {
 				}
 			case ebpftracer.EventTypeProcessExit:
 				if c := r.containersByPid[e.Pid]; c != nil {
+					container = c
 					c.onProcessExit(e.Pid, e.Reason == ebpftracer.EventReasonOOMKill)
 				}
-				delete(r.containersByPid, e.Pid)
+				r.deletePid(e.Pid)
 
 			case ebpftracer.EventTypeFileOpen:
 				if c := r.getOrCreateContainer(e.Pid); c != nil {
+					container = c
 					c.onFileOpen(e.Pid, e.Fd)
 				}
 
 			case ebpftracer.EventTypeListenOpen:
 				if c := r.getOrCreateContainer(e.Pid); c != nil {
+					container = c
 					c.onListenOpen(e.Pid, e.SrcAddr, false)
 				} else {
 					klog.Infoln("TCP listen open from unknown container", e)
 				}
 			case ebpftracer.EventTypeListenClose:
 				if c := r.containersByPid[e.Pid]; c != nil {
+					container = c
 					c.onListenClose(e.Pid, e.SrcAddr)
 				}
 
 			case ebpftracer.EventTypeConnectionOpen:
 				if c := r.getOrCreateContainer(e.Pid); c != nil {
-					c.onConnectionOpen(e.Pid, e.Fd, e.SrcAddr, e.DstAddr, e.Timestamp, false)
+					container = c
+					if c.onConnectionOpen(e.Pid, e.Fd, e.SrcAddr, e.DstAddr, e.Timestamp, false) {
+						r.containersByAddrPair[AddrPair{src: e.SrcAddr, dst: e.DstAddr}] = c
+					}
 					c.attachTlsUprobes(r.tracer, e.Pid)
 				} else {
 					klog.Infoln("TCP connection from unknown container", e)
 				}
 			case ebpftracer.EventTypeConnectionError:
 				if c := r.getOrCreateContainer(e.Pid); c != nil {
+					container = c
 					c.onConnectionOpen(e.Pid, e.Fd, e.SrcAddr, e.DstAddr, 0, true)
 				} else {
 					klog.Infoln("TCP connection error from unknown container", e)
 				}
 			case ebpftracer.EventTypeConnectionClose:
 				srcDst := AddrPair{src: e.SrcAddr, dst: e.DstAddr}
-				for _, c := range r.containersById {
+				if c := r.containersByAddrPair[srcDst]; c != nil {
 					if c.onConnectionClose(srcDst) {
-						break
+						container = c
+					} else {
+						delete(r.containersByAddrPair, srcDst)
 					}
 				}
 			case ebpftracer.EventTypeTCPRetransmit:
 				srcDst := AddrPair{src: e.SrcAddr, dst: e.DstAddr}
-				for _, c := range r.containersById {
+				if c := r.containersByAddrPair[srcDst]; c != nil {
 					if c.onRetransmit(srcDst) {
-						break
+						container = c
+					} else {
+						delete(r.containersByAddrPair, srcDst)
 					}
 				}
 			case ebpftracer.EventTypeL7Request:
@@ -265,18 +369,32 @@ func (r *Registry) handleEvents(ch <-chan ebpftracer.Event) {
 					continue
 				}
 				if c := r.containersByPid[e.Pid]; c != nil {
-					ip2fqdn := c.onL7Request(e.Pid, e.Fd, e.Timestamp, e.L7Request)
-					r.ip2fqdnLock.Lock()
-					for ip, fqdn := range ip2fqdn {
-						r.ip2fqdn[ip] = fqdn
-					}
-					r.ip2fqdnLock.Unlock()
+					// the request is handled and published by the worker
+					r.dispatchL7Request(c, e)
+					continue
 				}
 			}
+			r.streams.publish(e, container)
+		}
+	}
+}
+
+// setPidContainer records the container of the process, only the processes of the tracked containers are traced in the kernel.
+func (r *Registry) setPidContainer(pid uint32, c *Container) {
+	r.containersByPid[pid] = c
+	if c != nil {
+		if err := r.tracer.TracePid(pid); err != nil {
+			r.tracePidErrors.Add(1)
+			klog.Warningln(err)
 		}
 	}
 }
 
+func (r *Registry) deletePid(pid uint32) {
+	delete(r.containersByPid, pid)
+	r.tracer.UntracePid(pid)
+}
+
 func (r *Registry) getOrCreateContainer(pid uint32) *Container {
 	if c, seen := r.containersByPid[pid]; c != nil {
 		return c
@@ -291,9 +409,13 @@ func (r *Registry) getOrCreateContainer(pid uint32) *Container {
 		return nil
 	}
 	if c := r.containersByCgroupId[cg.Id]; c != nil {
-		r.containersByPid[pid] = c
+		r.setPidContainer(pid, c)
 		return c
 	}
+	if _, ok := r.excludedCgroups[cg.Id]; ok {
+		r.setPidContainer(pid, nil)
+		return nil
+	}
 	if cg.ContainerType == cgroup.ContainerTypeSandbox {
 		cmdline := proc.GetCmdline(pid)
 		parts := bytes.Split(cmdline, []byte{0})
@@ -317,10 +439,16 @@ func (r *Registry) getOrCreateContainer(pid uint32) *Container {
 			klog.InfoS("ignoring without persisting", "cg", cg.Id, "pid", pid)
 		} else {
 			klog.InfoS("ignoring", "cg", cg.Id, "pid", pid)
-			r.containersByPid[pid] = nil
+			r.setPidContainer(pid, nil)
 		}
 		return nil
 	}
+	if !r.filter.allowed(id, cg, md) {
+		klog.InfoS("ignoring excluded by the container filter", "cg", cg.Id, "pid", pid, "id", id)
+		r.setPidContainer(pid, nil)
+		r.excludedCgroups[cg.Id] = cg
+		return nil
+	}
 	if c := r.containersById[id]; c != nil {
 		klog.Warningln("id conflict:", id)
 		if cg.CreatedAt().After(c.cgroup.CreatedAt()) {
@@ -332,7 +460,7 @@ func (r *Registry) getOrCreateContainer(pid uint32) *Container {
 				c.nsConntrack = nil
 			}
 		}
-		r.containersByPid[pid] = c
+		r.setPidContainer(pid, c)
 		r.containersByCgroupId[cg.Id] = c
 		return c
 	}
@@ -347,7 +475,7 @@ func (r *Registry) getOrCreateContainer(pid uint32) *Container {
 		klog.Warningln("failed to register container:", err)
 		return nil
 	}
-	r.containersByPid[pid] = c
+	r.setPidContainer(pid, c)
 	r.containersByCgroupId[cg.Id] = c
 	r.containersById[id] = c
 	return c
@@ -0,0 +1,58 @@
+package containers
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/cilium/ebpf/link"
+	"github.com/coroot/coroot-node-agent/ebpftracer"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeTracer struct {
+	traced   map[uint32]bool
+	capacity int
+}
+
+func (t *fakeTracer) Run(chan<- ebpftracer.Event) error { return nil }
+func (t *fakeTracer) Close()                            {}
+
+func (t *fakeTracer) TracePid(pid uint32) error {
+	if !t.traced[pid] && len(t.traced) >= t.capacity {
+		return errors.New("the map is full")
+	}
+	t.traced[pid] = true
+	return nil
+}
+
+func (t *fakeTracer) UntracePid(pid uint32) {
+	delete(t.traced, pid)
+}
+
+func (t *fakeTracer) AttachOpenSslUprobes(uint32) []link.Link       { return nil }
+func (t *fakeTracer) AttachGoTlsUprobes(uint32) ([]link.Link, bool) { return nil, false }
+
+func TestRegistryTracedPids(t *testing.T) {
+	tracer := &fakeTracer{traced: map[uint32]bool{}, capacity: 2}
+	r := &Registry{tracer: tracer, containersByPid: map[uint32]*Container{}}
+	c := &Container{id: "/docker/app"}
+
+	r.setPidContainer(10, c)
+	r.setPidContainer(20, nil) // ignored processes aren't traced
+	assert.Equal(t, map[uint32]bool{10: true}, tracer.traced)
+	assert.Equal(t, map[uint32]*Container{10: c, 20: nil}, r.containersByPid)
+
+	r.setPidContainer(30, c)
+	r.setPidContainer(40, c)
+	assert.Equal(t, map[uint32]bool{10: true, 30: true}, tracer.traced)
+	assert.Equal(t, uint64(1), r.tracePidErrors.Load())
+
+	r.deletePid(10)
+	r.deletePid(20)
+	assert.Equal(t, map[uint32]bool{30: true}, tracer.traced)
+	assert.Equal(t, map[uint32]*Container{30: c, 40: c}, r.containersByPid)
+
+	r.setPidContainer(40, c) // the pid is traced once there is room for it
+	assert.Equal(t, map[uint32]bool{30: true, 40: true}, tracer.traced)
+	assert.Equal(t, uint64(1), r.tracePidErrors.Load())
+}
@@ -0,0 +1,131 @@
+package containers
+
+import (
+	"sync"
+	"time"
+
+	"github.com/coroot/coroot-node-agent/capabilities"
+	"github.com/coroot/coroot-node-agent/flags"
+	"github.com/coroot/coroot-node-agent/proc"
+	"github.com/prometheus/client_golang/prometheus"
+	"inet.af/netaddr"
+)
+
+// containerSamples holds the results of the probes that may take long (pinging upstreams, reading JVM perf data,
+// stat'ing filesystems). The probes are run by the background samplers, so Collect only reads the latest results
+// and never holds the container lock while waiting for I/O.
+type containerSamples struct {
+	lock     sync.RWMutex
+	rtt      map[netaddr.IP]float64
+	mounts   map[string]map[string]*proc.FSStat
+	jvm      []prometheus.Metric
+	appTypes map[string]struct{}
+}
+
+func (c *Container) runSamplers() {
+	go c.runSampler(*flags.ScrapeInterval, c.sampleRuntime)
+	if !*flags.DisablePinger && capabilities.Enabled(capabilities.FeaturePinger) {
+		go c.runSampler(*flags.ScrapeInterval, c.samplePing)
+	}
+}
+
+func (c *Container) runSampler(interval time.Duration, sample func()) {
+	// the first sample is taken right away, so that the metrics aren't missing until the first tick
+	sample()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			sample()
+		}
+	}
+}
+
+func (c *Container) samplePing() {
+	rtt := c.ping()
+	c.samples.lock.Lock()
+	c.samples.rtt = rtt
+	c.samples.lock.Unlock()
+}
+
+func (c *Container) sampleRuntime() {
+	c.lock.RLock()
+	golangApps := make(map[uint32]bool, len(c.processes))
+	for pid, p := range c.processes {
+		golangApps[pid] = p.isGolangApp
+	}
+	c.lock.RUnlock()
+
+	appTypes := map[string]struct{}{}
+	var jvm []prometheus.Metric
+	seenJvms := map[string]bool{}
+	for pid, golangApp := range golangApps {
+		cmdline := proc.GetCmdline(pid)
+		if len(cmdline) == 0 {
+			continue
+		}
+		if appType := guessApplicationType(cmdline); appType != "" {
+			appTypes[appType] = struct{}{}
+		}
+		if golangApp {
+			appTypes["golang"] = struct{}{}
+		}
+		if isJvm(cmdline) {
+			name, jMetrics := jvmMetrics(pid)
+			if len(jMetrics) > 0 && !seenJvms[name] {
+				seenJvms[name] = true
+				jvm = append(jvm, jMetrics...)
+			}
+		}
+	}
+	mounts := c.getMounts()
+
+	c.samples.lock.Lock()
+	c.samples.appTypes = appTypes
+	c.samples.jvm = jvm
+	c.samples.mounts = mounts
+	c.samples.lock.Unlock()
+}
+
+// statfs reports the same for any mount of a filesystem, and the containers often share the filesystems
+// (e.g., host volumes). The samplers run independently, so the results are shared between the containers
+// for half of the sampling interval rather than within a sampling round.
+var fsStats = struct {
+	lock     sync.Mutex
+	byDevice map[string]fsStatEntry
+}{byDevice: map[string]fsStatEntry{}}
+
+type fsStatEntry struct {
+	stat proc.FSStat
+	at   time.Time
+}
+
+func statFS(mi proc.MountInfo, pids []uint32) *proc.FSStat {
+	now := time.Now()
+	ttl := *flags.ScrapeInterval / 2
+	fsStats.lock.Lock()
+	e, ok := fsStats.byDevice[mi.MajorMinor]
+	fsStats.lock.Unlock()
+	if ok && now.Sub(e.at) < ttl {
+		return &e.stat
+	}
+	for _, pid := range pids {
+		s, err := proc.StatFS(proc.Path(pid, "root", mi.MountPoint))
+		if err != nil {
+			continue
+		}
+		fsStats.lock.Lock()
+		for device, e := range fsStats.byDevice {
+			if now.Sub(e.at) >= ttl {
+				delete(fsStats.byDevice, device)
+			}
+		}
+		fsStats.byDevice[mi.MajorMinor] = fsStatEntry{stat: s, at: now}
+		fsStats.lock.Unlock()
+		return &s
+	}
+	return nil
+}
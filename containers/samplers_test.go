@@ -0,0 +1,29 @@
+package containers
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/coroot/coroot-node-agent/flags"
+	"github.com/coroot/coroot-node-agent/proc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatFS(t *testing.T) {
+	defer func(v time.Duration) { *flags.ScrapeInterval = v }(*flags.ScrapeInterval)
+	*flags.ScrapeInterval = time.Minute
+
+	pid := uint32(os.Getpid())
+	root := proc.MountInfo{MajorMinor: "test:1", MountPoint: "/"}
+
+	s := statFS(root, []uint32{pid})
+	require.NotNil(t, s)
+	cached := statFS(proc.MountInfo{MajorMinor: root.MajorMinor, MountPoint: "/mnt"}, nil)
+	require.NotNil(t, cached, "another mount of the same filesystem")
+	assert.Equal(t, *s, *cached)
+
+	assert.Nil(t, statFS(proc.MountInfo{MajorMinor: "test:2", MountPoint: "/"}, nil))
+	assert.Nil(t, statFS(proc.MountInfo{MajorMinor: "test:3", MountPoint: "/nonexistent"}, []uint32{pid}))
+}
@@ -0,0 +1,289 @@
+package containers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/coroot/coroot-node-agent/ebpftracer"
+	"github.com/coroot/coroot-node-agent/ebpftracer/l7"
+	"inet.af/netaddr"
+	"k8s.io/klog/v2"
+)
+
+const (
+	streamBufferSize    = 1000
+	streamDropsInterval = time.Second
+)
+
+type StreamFilter struct {
+	ContainerId ContainerID
+	Pid         uint32
+	Types       map[string]bool
+	Protocols   map[string]bool
+	Destination string
+}
+
+type StreamEvent struct {
+	Time        time.Time   `json:"time"`
+	Type        string      `json:"type"`
+	Reason      string      `json:"reason,omitempty"`
+	ContainerId ContainerID `json:"container_id,omitempty"`
+	Pid         uint32      `json:"pid,omitempty"`
+	Fd          uint64      `json:"fd,omitempty"`
+	Src         string      `json:"src,omitempty"`
+	Dst         string      `json:"dst,omitempty"`
+	ActualDst   string      `json:"actual_dst,omitempty"`
+	L7          *StreamL7   `json:"l7,omitempty"`
+	Dropped     uint64      `json:"dropped,omitempty"`
+}
+
+type StreamL7 struct {
+	Protocol string  `json:"protocol"`
+	Method   string  `json:"method,omitempty"`
+	Status   string  `json:"status"`
+	Duration float64 `json:"duration"`
+	Request  string  `json:"request,omitempty"`
+}
+
+type streamEvent struct {
+	time        time.Time
+	event       ebpftracer.Event
+	containerId ContainerID
+	actualDst   netaddr.IPPort
+}
+
+type eventStream struct {
+	filter  StreamFilter
+	ch      chan streamEvent
+	dropped atomic.Uint64
+}
+
+// eventStreams mirrors the events handled by the registry to the subscribers.
+// Publishing never blocks: if a subscriber doesn't keep up, its events are dropped.
+type eventStreams struct {
+	lock    sync.RWMutex
+	streams map[*eventStream]struct{}
+}
+
+func (s *eventStreams) subscribe(filter StreamFilter) *eventStream {
+	es := &eventStream{filter: filter, ch: make(chan streamEvent, streamBufferSize)}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.streams == nil {
+		s.streams = map[*eventStream]struct{}{}
+	}
+	s.streams[es] = struct{}{}
+	return es
+}
+
+func (s *eventStreams) unsubscribe(es *eventStream) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.streams, es)
+}
+
+func (s *eventStreams) publish(e ebpftracer.Event, c *Container) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if len(s.streams) == 0 {
+		return
+	}
+	se := streamEvent{time: time.Now(), event: e}
+	if c != nil {
+		se.containerId = c.id
+		if e.Type == ebpftracer.EventTypeConnectionOpen || e.Type == ebpftracer.EventTypeL7Request {
+			se.actualDst = c.actualDestination(e.Pid, e.Fd)
+		}
+	}
+	for es := range s.streams {
+		if !es.filter.match(se) {
+			continue
+		}
+		select {
+		case es.ch <- se:
+		default:
+			es.dropped.Add(1)
+		}
+	}
+}
+
+func (f StreamFilter) match(se streamEvent) bool {
+	e := se.event
+	if f.ContainerId != "" && se.containerId != f.ContainerId {
+		return false
+	}
+	if f.Pid != 0 && e.Pid != f.Pid {
+		return false
+	}
+	if len(f.Types) > 0 && !f.Types[e.Type.String()] {
+		return false
+	}
+	if len(f.Protocols) > 0 && (e.L7Request == nil || !f.Protocols[strings.ToLower(e.L7Request.Protocol.String())]) {
+		return false
+	}
+	if f.Destination != "" {
+		matched := false
+		for _, dst := range []netaddr.IPPort{e.DstAddr, se.actualDst} {
+			if dst.IsZero() {
+				continue
+			}
+			if dst.String() == f.Destination || dst.IP().String() == f.Destination {
+				matched = true
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *Container) actualDestination(pid uint32, fd uint64) netaddr.IPPort {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	if conn := c.connectionsByPidFd[PidFd{Pid: pid, Fd: fd}]; conn != nil {
+		return conn.ActualDest
+	}
+	return netaddr.IPPort{}
+}
+
+// EventStreamHandler streams the events matching the filter as newline-delimited JSON until the client disconnects.
+func (r *Registry) EventStreamHandler(w http.ResponseWriter, req *http.Request) {
+	filter, err := parseStreamFilter(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	es := r.streams.subscribe(filter)
+	defer r.streams.unsubscribe(es)
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	enc := json.NewEncoder(w)
+	ticker := time.NewTicker(streamDropsInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-req.Context().Done():
+			return
+		case <-ticker.C:
+			if dropped := es.dropped.Swap(0); dropped > 0 {
+				err = enc.Encode(StreamEvent{Time: time.Now(), Type: "dropped", Dropped: dropped})
+			}
+		case se := <-es.ch:
+			err = enc.Encode(se.render())
+		}
+		if err != nil {
+			klog.Warningln("event stream:", err)
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+func parseStreamFilter(req *http.Request) (StreamFilter, error) {
+	q := req.URL.Query()
+	f := StreamFilter{
+		ContainerId: ContainerID(q.Get("container_id")),
+		Types:       map[string]bool{},
+		Protocols:   map[string]bool{},
+		Destination: q.Get("destination"),
+	}
+	if pid := q.Get("pid"); pid != "" {
+		v, err := strconv.ParseUint(pid, 10, 32)
+		if err != nil {
+			return f, fmt.Errorf("invalid pid: %s", pid)
+		}
+		f.Pid = uint32(v)
+	}
+	for _, v := range q["type"] {
+		for _, t := range strings.Split(v, ",") {
+			f.Types[strings.TrimSpace(t)] = true
+		}
+	}
+	for _, v := range q["protocol"] {
+		for _, p := range strings.Split(v, ",") {
+			f.Protocols[strings.ToLower(strings.TrimSpace(p))] = true
+		}
+	}
+	return f, nil
+}
+
+func (se streamEvent) render() StreamEvent {
+	e := se.event
+	res := StreamEvent{
+		Time:        se.time,
+		Type:        e.Type.String(),
+		ContainerId: se.containerId,
+		Pid:         e.Pid,
+		Fd:          e.Fd,
+	}
+	if e.Reason == ebpftracer.EventReasonOOMKill {
+		res.Reason = "oom-kill"
+	}
+	if !e.SrcAddr.IsZero() {
+		res.Src = e.SrcAddr.String()
+	}
+	if !e.DstAddr.IsZero() {
+		res.Dst = e.DstAddr.String()
+	}
+	if !se.actualDst.IsZero() {
+		res.ActualDst = se.actualDst.String()
+	}
+	if r := e.L7Request; r != nil {
+		res.L7 = &StreamL7{
+			Protocol: r.Protocol.String(),
+			Status:   r.Status.String(),
+			Duration: r.Duration.Seconds(),
+			Request:  decodeL7Request(r),
+		}
+		if r.Method != l7.MethodUnknown {
+			res.L7.Method = r.Method.String()
+		}
+	}
+	return res
+}
+
+// decodeL7Request decodes the payload for display. The parsers of the connection keep the state (prepared statements,
+// HPACK tables) and must not be used outside the events loop, so fresh parsers are used here,
+// and HTTP/2 payloads are not decoded.
+func decodeL7Request(r *l7.RequestData) string {
+	switch r.Protocol {
+	case l7.ProtocolHTTP:
+		method, path := l7.ParseHttp(r.Payload)
+		return strings.TrimSpace(method + " " + path)
+	case l7.ProtocolDNS:
+		t, fqdn, ips := l7.ParseDns(r.Payload)
+		res := strings.TrimSpace(t + " " + fqdn)
+		for _, ip := range ips {
+			res += " " + ip.String()
+		}
+		return res
+	case l7.ProtocolPostgres:
+		return l7.NewPostgresParser().Parse(r.Payload)
+	case l7.ProtocolMysql:
+		return l7.NewMysqlParser().Parse(r.Payload, r.StatementId)
+	case l7.ProtocolMemcached:
+		cmd, items := l7.ParseMemcached(r.Payload)
+		return strings.TrimSpace(cmd + " " + strings.Join(items, " "))
+	case l7.ProtocolRedis:
+		cmd, args := l7.ParseRedis(r.Payload)
+		return strings.TrimSpace(cmd + " " + args)
+	case l7.ProtocolMongo:
+		return l7.ParseMongo(r.Payload)
+	}
+	return ""
+}
@@ -0,0 +1,117 @@
+package containers
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/coroot/coroot-node-agent/ebpftracer"
+	"github.com/coroot/coroot-node-agent/ebpftracer/l7"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/dns/dnsmessage"
+	"inet.af/netaddr"
+)
+
+func TestStreamFilter(t *testing.T) {
+	conn := streamEvent{
+		containerId: "/k8s/default/web/app",
+		event: ebpftracer.Event{
+			Type:    ebpftracer.EventTypeConnectionOpen,
+			Pid:     10,
+			SrcAddr: netaddr.MustParseIPPort("10.0.0.1:40000"),
+			DstAddr: netaddr.MustParseIPPort("10.96.0.10:5432"),
+		},
+		actualDst: netaddr.MustParseIPPort("10.0.0.2:5432"),
+	}
+	request := streamEvent{
+		containerId: "/k8s/default/web/app",
+		event: ebpftracer.Event{
+			Type:      ebpftracer.EventTypeL7Request,
+			Pid:       10,
+			DstAddr:   netaddr.MustParseIPPort("10.96.0.10:5432"),
+			L7Request: &l7.RequestData{Protocol: l7.ProtocolPostgres},
+		},
+	}
+	exit := streamEvent{event: ebpftracer.Event{Type: ebpftracer.EventTypeProcessExit, Pid: 20}}
+
+	for _, tc := range []struct {
+		name    string
+		filter  StreamFilter
+		matched []streamEvent
+	}{
+		{name: "empty", filter: StreamFilter{}, matched: []streamEvent{conn, request, exit}},
+		{name: "container", filter: StreamFilter{ContainerId: "/k8s/default/web/app"}, matched: []streamEvent{conn, request}},
+		{name: "pid", filter: StreamFilter{Pid: 20}, matched: []streamEvent{exit}},
+		{name: "types", filter: StreamFilter{Types: map[string]bool{"connection-open": true, "process-exit": true}}, matched: []streamEvent{conn, exit}},
+		{name: "protocol", filter: StreamFilter{Protocols: map[string]bool{"postgres": true}}, matched: []streamEvent{request}},
+		{name: "other protocol", filter: StreamFilter{Protocols: map[string]bool{"mysql": true}}},
+		{name: "destination address", filter: StreamFilter{Destination: "10.96.0.10:5432"}, matched: []streamEvent{conn, request}},
+		{name: "destination ip", filter: StreamFilter{Destination: "10.96.0.10"}, matched: []streamEvent{conn, request}},
+		{name: "actual destination", filter: StreamFilter{Destination: "10.0.0.2:5432"}, matched: []streamEvent{conn}},
+		{name: "source is not a destination", filter: StreamFilter{Destination: "10.0.0.1"}},
+		{name: "all conditions", filter: StreamFilter{ContainerId: "/k8s/default/web/app", Pid: 10, Types: map[string]bool{"l7-request": true}, Destination: "10.96.0.10"}, matched: []streamEvent{request}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var matched []streamEvent
+			for _, se := range []streamEvent{conn, request, exit} {
+				if tc.filter.match(se) {
+					matched = append(matched, se)
+				}
+			}
+			assert.Equal(t, tc.matched, matched)
+		})
+	}
+}
+
+func TestParseStreamFilter(t *testing.T) {
+	f, err := parseStreamFilter(httptest.NewRequest("GET", "/debug/events?container_id=/docker/abc&pid=10&type=connection-open,%20l7-request&protocol=HTTP&protocol=Postgres&destination=10.0.0.1", nil))
+	require.NoError(t, err)
+	assert.Equal(t, StreamFilter{
+		ContainerId: "/docker/abc",
+		Pid:         10,
+		Types:       map[string]bool{"connection-open": true, "l7-request": true},
+		Protocols:   map[string]bool{"http": true, "postgres": true},
+		Destination: "10.0.0.1",
+	}, f)
+
+	_, err = parseStreamFilter(httptest.NewRequest("GET", "/debug/events?pid=-1", nil))
+	assert.Error(t, err)
+}
+
+func TestDecodeL7Request(t *testing.T) {
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{Response: true})
+	require.NoError(t, b.StartQuestions())
+	require.NoError(t, b.Question(dnsmessage.Question{Name: dnsmessage.MustNewName("example.com."), Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET}))
+	require.NoError(t, b.StartAnswers())
+	require.NoError(t, b.AResource(dnsmessage.ResourceHeader{Name: dnsmessage.MustNewName("example.com."), Class: dnsmessage.ClassINET}, dnsmessage.AResource{A: [4]byte{1, 2, 3, 4}}))
+	dns, err := b.Finish()
+	require.NoError(t, err)
+
+	mysqlQuery := append([]byte{9, 0, 0, 0, l7.MysqlComQuery}, "SELECT 1"...)
+	mysqlExecute := []byte{10, 0, 0, 0, l7.MysqlComStmtExecute, 5, 0, 0, 0, 0, 1, 0, 0, 0}
+	postgresQuery := append([]byte{l7.PostgresFrameQuery, 0, 0, 0, 13}, "SELECT 1\x00"...)
+	postgresBind := append([]byte{l7.PostgresFrameBind, 0, 0, 0, 12}, "\x00stmt1\x00"...)
+
+	for _, tc := range []struct {
+		name     string
+		protocol l7.Protocol
+		payload  []byte
+		expected string
+	}{
+		{name: "http", protocol: l7.ProtocolHTTP, payload: []byte("GET /api/users?id=1 HTTP/1.1\r\nHost: web\r\n\r\n"), expected: "GET /api/users?id=1"},
+		{name: "http partial", protocol: l7.ProtocolHTTP, payload: []byte("garbage"), expected: ""},
+		{name: "dns", protocol: l7.ProtocolDNS, payload: dns, expected: "TypeA example.com 1.2.3.4"},
+		{name: "dns invalid", protocol: l7.ProtocolDNS, payload: []byte{1, 2}, expected: ""},
+		{name: "postgres query", protocol: l7.ProtocolPostgres, payload: postgresQuery, expected: "SELECT 1"},
+		{name: "postgres bind", protocol: l7.ProtocolPostgres, payload: postgresBind, expected: "EXECUTE stmt1 /* unknown */"},
+		{name: "mysql query", protocol: l7.ProtocolMysql, payload: mysqlQuery, expected: "SELECT 1"},
+		{name: "mysql execute", protocol: l7.ProtocolMysql, payload: mysqlExecute, expected: "EXECUTE 5 /* unknown */"},
+		{name: "memcached", protocol: l7.ProtocolMemcached, payload: []byte("get k1 k2\r\n"), expected: "get k1 k2"},
+		{name: "redis", protocol: l7.ProtocolRedis, payload: []byte("*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$5\r\nvalue\r\n"), expected: "SET key ..."},
+		{name: "http2 is not decoded", protocol: l7.ProtocolHTTP2, payload: []byte("PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"), expected: ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, decodeL7Request(&l7.RequestData{Protocol: tc.protocol, Payload: tc.payload}))
+		})
+	}
+}
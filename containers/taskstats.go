@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"sync"
 
+	"github.com/coroot/coroot-node-agent/capabilities"
 	"github.com/mdlayher/taskstats"
 )
 
@@ -13,6 +14,9 @@ var (
 )
 
 func TaskstatsInit() error {
+	if err := capabilities.Check(capabilities.FeatureTaskstats); err != nil {
+		return err
+	}
 	c, err := taskstats.New()
 	if err != nil {
 		return err
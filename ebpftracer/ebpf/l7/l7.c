@@ -81,6 +81,45 @@ struct {
     __uint(value_size, sizeof(int));
 } l7_events SEC(".maps");
 
+struct l7_protocol_config {
+    __u32 enabled;
+    __u32 payload_size;
+};
+
+// per-protocol settings indexed by the protocol, populated by the agent
+struct {
+    __uint(type, BPF_MAP_TYPE_ARRAY);
+    __uint(key_size, sizeof(__u32));
+    __uint(value_size, sizeof(struct l7_protocol_config));
+    __uint(max_entries, 16);
+} l7_config SEC(".maps");
+
+// returns the number of payload bytes to capture for the protocol or -1 if the protocol is disabled
+static inline __attribute__((__always_inline__))
+__s64 protocol_payload_size(__u32 protocol, __u64 size) {
+    struct l7_protocol_config *c = bpf_map_lookup_elem(&l7_config, &protocol);
+    if (!c || !c->enabled) {
+        return -1;
+    }
+    return MIN(size, c->payload_size);
+}
+
+// pids of the processes belonging to the tracked containers, populated by the agent.
+// The agent sets max_entries to kernel.pid_max on load, the entries are allocated on insert.
+struct {
+    __uint(type, BPF_MAP_TYPE_HASH);
+    __uint(key_size, sizeof(__u32));
+    __uint(value_size, sizeof(__u8));
+    __uint(max_entries, 32768);
+    __uint(map_flags, BPF_F_NO_PREALLOC);
+} traced_pids SEC(".maps");
+
+static inline __attribute__((__always_inline__))
+int is_traced(__u64 id) {
+    __u32 pid = (id >> 32) & 0x7FFFFFFF; // the TLS read ids have the highest bit set
+    return bpf_map_lookup_elem(&traced_pids, &pid) != NULL;
+}
+
 struct read_args {
     __u64 fd;
     char* buf;
@@ -178,7 +217,10 @@ void send_event(void *ctx, struct l7_event *e, __u32 pid, __u64 fd) {
     }
     e->fd = fd;
     e->pid = pid;
-    bpf_perf_event_output(ctx, &l7_events, BPF_F_CURRENT_CPU, e, sizeof(*e));
+    // only the captured part of the payload is sent
+    __u64 size = e->payload_size;
+    TRUNCATE_PAYLOAD_SIZE(size);
+    bpf_perf_event_output(ctx, &l7_events, BPF_F_CURRENT_CPU, e, sizeof(*e) - MAX_PAYLOAD_SIZE + size);
 }
 
 static inline __attribute__((__always_inline__))
@@ -215,6 +257,9 @@ __u64 read_iovec(char *iovec, __u64 iovlen, __u64 ret, char *buf) {
 static inline __attribute__((__always_inline__))
 int trace_enter_write(void *ctx, __u64 fd, __u16 is_tls, char *buf, __u64 size, __u64 iovlen) {
     __u64 id = bpf_get_current_pid_tgid();
+    if (!is_traced(id)) {
+        return 0;
+    }
     __u32 zero = 0;
 
     char* payload = buf;
@@ -252,10 +297,14 @@ int trace_enter_write(void *ctx, __u64 fd, __u16 is_tls, char *buf, __u64 size,
             if (!e) {
                 return 0;
             }
+            __s64 n = protocol_payload_size(PROTOCOL_POSTGRES, size);
+            if (n < 0) {
+                return 0;
+            }
             e->protocol = PROTOCOL_POSTGRES;
             e->method = METHOD_STATEMENT_CLOSE;
-            e->payload_size = size;
-            COPY_PAYLOAD(e->payload, size, payload);
+            e->payload_size = n;
+            COPY_PAYLOAD(e->payload, n, payload);
             send_event(ctx, e, k.pid, k.fd);
             return 0;
         }
@@ -270,10 +319,14 @@ int trace_enter_write(void *ctx, __u64 fd, __u16 is_tls, char *buf, __u64 size,
             if (!e) {
                 return 0;
             }
+            __s64 n = protocol_payload_size(PROTOCOL_MYSQL, size);
+            if (n < 0) {
+                return 0;
+            }
             e->protocol = PROTOCOL_MYSQL;
             e->method = METHOD_STATEMENT_CLOSE;
-            e->payload_size = size;
-            COPY_PAYLOAD(e->payload, size, payload);
+            e->payload_size = n;
+            COPY_PAYLOAD(e->payload, n, payload);
             send_event(ctx, e, k.pid, k.fd);
             return 0;
         }
@@ -281,21 +334,29 @@ int trace_enter_write(void *ctx, __u64 fd, __u16 is_tls, char *buf, __u64 size,
     } else if (is_mongo_query(payload, size)) {
         req->protocol = PROTOCOL_MONGO;
     } else if (is_rabbitmq_produce(payload, size)) {
+        if (protocol_payload_size(PROTOCOL_RABBITMQ, 0) < 0) {
+            return 0;
+        }
         struct l7_event *e = bpf_map_lookup_elem(&l7_event_heap, &zero);
         if (!e) {
             return 0;
         }
         e->protocol = PROTOCOL_RABBITMQ;
         e->method = METHOD_PRODUCE;
+        e->payload_size = 0;
         send_event(ctx, e, k.pid, k.fd);
         return 0;
     } else if (nats_method(payload, size) == METHOD_PRODUCE) {
+        if (protocol_payload_size(PROTOCOL_NATS, 0) < 0) {
+            return 0;
+        }
         struct l7_event *e = bpf_map_lookup_elem(&l7_event_heap, &zero);
         if (!e) {
             return 0;
         }
         e->protocol = PROTOCOL_NATS;
         e->method = METHOD_PRODUCE;
+        e->payload_size = 0;
         send_event(ctx, e, k.pid, k.fd);
         return 0;
     } else if (is_cassandra_request(payload, size, &k.stream_id)) {
@@ -311,11 +372,15 @@ int trace_enter_write(void *ctx, __u64 fd, __u16 is_tls, char *buf, __u64 size,
         if (!e) {
             return 0;
         }
+        __s64 n = protocol_payload_size(PROTOCOL_HTTP2, size);
+        if (n < 0) {
+            return 0;
+        }
         e->protocol = PROTOCOL_HTTP2;
         e->method = METHOD_HTTP2_CLIENT_FRAMES;
         e->duration = bpf_ktime_get_ns();
-        e->payload_size = size;
-        COPY_PAYLOAD(e->payload, size, payload);
+        e->payload_size = n;
+        COPY_PAYLOAD(e->payload, n, payload);
         send_event(ctx, e, k.pid, k.fd);
         return 0;
     } else if (is_dubbo2_request(payload, size)) {
@@ -327,16 +392,24 @@ int trace_enter_write(void *ctx, __u64 fd, __u16 is_tls, char *buf, __u64 size,
     if (req->protocol == PROTOCOL_UNKNOWN) {
         return 0;
     }
+    __s64 n = protocol_payload_size(req->protocol, size);
+    if (n < 0) {
+        return 0;
+    }
+    req->payload_size = n;
     if (req->ns == 0) {
         req->ns = bpf_ktime_get_ns();
     }
-    COPY_PAYLOAD(req->payload, size, payload);
+    COPY_PAYLOAD(req->payload, n, payload);
     bpf_map_update_elem(&active_l7_requests, &k, req, BPF_NOEXIST);
     return 0;
 }
 
 static inline __attribute__((__always_inline__))
 int trace_enter_read(__u64 id, __u64 fd, char *buf, __u64 *ret, __u64 iovlen) {
+    if (!is_traced(id)) {
+        return 0;
+    }
     struct read_args args = {};
     args.fd = fd;
     args.buf = buf;
@@ -397,12 +470,18 @@ int trace_exit_read(void *ctx, __u64 id, __u32 pid, __u16 is_tls, long int ret)
     e->payload_size = 0;
 
     if (is_rabbitmq_consume(payload, ret)) {
+        if (protocol_payload_size(PROTOCOL_RABBITMQ, 0) < 0) {
+            return 0;
+        }
         e->protocol = PROTOCOL_RABBITMQ;
         e->method = METHOD_CONSUME;
         send_event(ctx, e, k.pid, k.fd);
         return 0;
     }
     if (nats_method(payload, ret) == METHOD_CONSUME) {
+        if (protocol_payload_size(PROTOCOL_NATS, 0) < 0) {
+            return 0;
+        }
         e->protocol = PROTOCOL_NATS;
         e->method = METHOD_CONSUME;
         send_event(ctx, e, k.pid, k.fd);
@@ -417,10 +496,14 @@ int trace_exit_read(void *ctx, __u64 id, __u32 pid, __u16 is_tls, long int ret)
             if (!req) {
                 return 0;
             }
+            __s64 n = protocol_payload_size(PROTOCOL_DNS, ret);
+            if (n < 0) {
+                return 0;
+            }
             e->protocol = PROTOCOL_DNS;
             e->duration = bpf_ktime_get_ns() - req->ns;
-            e->payload_size = ret;
-            COPY_PAYLOAD(e->payload, ret, payload);
+            e->payload_size = n;
+            COPY_PAYLOAD(e->payload, n, payload);
             send_event(ctx, e, k.pid, k.fd);
             bpf_map_delete_elem(&active_l7_requests, &k);
             return 0;
@@ -431,11 +514,15 @@ int trace_exit_read(void *ctx, __u64 id, __u32 pid, __u16 is_tls, long int ret)
             }
             response = 1;
         } else if (looks_like_http2_frame(payload, ret, METHOD_HTTP2_SERVER_FRAMES)) {
+            __s64 n = protocol_payload_size(PROTOCOL_HTTP2, ret);
+            if (n < 0) {
+                return 0;
+            }
             e->protocol = PROTOCOL_HTTP2;
             e->method = METHOD_HTTP2_SERVER_FRAMES;
             e->duration = bpf_ktime_get_ns();
-            e->payload_size = ret;
-            COPY_PAYLOAD(e->payload, ret, payload);
+            e->payload_size = n;
+            COPY_PAYLOAD(e->payload, n, payload);
             send_event(ctx, e, k.pid, k.fd);
             return 0;
         } else {
@@ -505,6 +592,9 @@ int sys_enter_writev(struct trace_event_raw_sys_enter_rw__stub* ctx) {
 
 SEC("tracepoint/syscalls/sys_enter_sendmsg")
 int sys_enter_sendmsg(struct trace_event_raw_sys_enter_rw__stub* ctx) {
+    if (!is_traced(bpf_get_current_pid_tgid())) {
+        return 0;
+    }
     struct user_msghdr msghdr = {};
     if (bpf_probe_read(&msghdr, sizeof(msghdr), (void *)ctx->buf)) {
         return 0;
@@ -519,6 +609,9 @@ struct mmsghdr {
 
 SEC("tracepoint/syscalls/sys_enter_sendmmsg")
 int sys_enter_sendmmsg(struct trace_event_raw_sys_enter_rw__stub* ctx) {
+    if (!is_traced(bpf_get_current_pid_tgid())) {
+        return 0;
+    }
     __u64 offset = 0;
     #pragma unroll
     for (int i = 0; i <= 1; i++) {
@@ -555,6 +648,9 @@ int sys_enter_readv(struct trace_event_raw_sys_enter_rw__stub* ctx) {
 SEC("tracepoint/syscalls/sys_enter_recvmsg")
 int sys_enter_recvmsg(struct trace_event_raw_sys_enter_rw__stub* ctx) {
     __u64 id = bpf_get_current_pid_tgid();
+    if (!is_traced(id)) {
+        return 0;
+    }
     struct user_msghdr msghdr = {};
     if (bpf_probe_read(&msghdr, sizeof(msghdr), (void *)ctx->buf)) {
         return 0;
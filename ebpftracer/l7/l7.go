@@ -2,6 +2,7 @@ package l7
 
 import (
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -23,6 +24,22 @@ const (
 	ProtocolDNS       Protocol = 13
 )
 
+var Protocols = []Protocol{
+	ProtocolHTTP, ProtocolPostgres, ProtocolRedis, ProtocolMemcached, ProtocolMysql, ProtocolMongo, ProtocolKafka,
+	ProtocolCassandra, ProtocolRabbitmq, ProtocolNats, ProtocolHTTP2, ProtocolDubbo2, ProtocolDNS,
+}
+
+// ParseProtocol returns the protocol by its case-insensitive name, e.g., http, postgres or dns.
+func ParseProtocol(name string) (Protocol, bool) {
+	name = strings.TrimSpace(name)
+	for _, p := range Protocols {
+		if strings.EqualFold(p.String(), name) {
+			return p, true
+		}
+	}
+	return 0, false
+}
+
 func (p Protocol) String() string {
 	switch p {
 	case ProtocolHTTP:
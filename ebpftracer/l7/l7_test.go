@@ -88,3 +88,16 @@ func TestParseMongo(t *testing.T) {
 	binary.LittleEndian.PutUint32(payload[mongoHeaderLength+mongoSectionKindLength:], dataSize+1)
 	assert.Equal(t, `<truncated>`, ParseMongo(payload))
 }
+
+func TestParseProtocol(t *testing.T) {
+	p, ok := ParseProtocol("postgres")
+	assert.True(t, ok)
+	assert.Equal(t, ProtocolPostgres, p)
+
+	p, ok = ParseProtocol(" HTTP2 ")
+	assert.True(t, ok)
+	assert.Equal(t, ProtocolHTTP2, p)
+
+	_, ok = ParseProtocol("grpc")
+	assert.False(t, ok)
+}
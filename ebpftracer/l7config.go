@@ -0,0 +1,78 @@
+package ebpftracer
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/cilium/ebpf"
+	"github.com/coroot/coroot-node-agent/ebpftracer/l7"
+)
+
+// DefaultPayloadSize is the largest payload the eBPF programs can capture.
+// The payload buffers are preallocated for every active request, so raising MaxPayloadSize costs locked kernel memory on every node.
+const DefaultPayloadSize = MaxPayloadSize - 1
+
+// L7Config defines which L7 protocols are traced and how many bytes of the request payload are captured.
+// Protocols missing from the maps are traced with DefaultPayloadSize.
+type L7Config struct {
+	Disabled     map[l7.Protocol]bool
+	PayloadSizes map[l7.Protocol]uint32
+}
+
+// the layout must match struct l7_protocol_config in ebpf/l7/l7.c
+type l7ProtocolConfig struct {
+	Enabled     uint32
+	PayloadSize uint32
+}
+
+// NewL7Config parses the names of the protocols to disable and the payload size overrides (`<protocol>=<bytes>`).
+func NewL7Config(disabled []string, payloadSizes []string) (L7Config, error) {
+	cfg := L7Config{Disabled: map[l7.Protocol]bool{}, PayloadSizes: map[l7.Protocol]uint32{}}
+	for _, name := range disabled {
+		protocol, ok := l7.ParseProtocol(name)
+		if !ok {
+			return cfg, fmt.Errorf("unknown L7 protocol %q", name)
+		}
+		cfg.Disabled[protocol] = true
+	}
+	for _, spec := range payloadSizes {
+		name, value, ok := strings.Cut(spec, "=")
+		if !ok {
+			return cfg, fmt.Errorf("invalid L7 payload size %q, expected <protocol>=<bytes>", spec)
+		}
+		protocol, ok := l7.ParseProtocol(name)
+		if !ok {
+			return cfg, fmt.Errorf("invalid L7 payload size %q: unknown protocol %q", spec, name)
+		}
+		size, err := strconv.ParseUint(strings.TrimSpace(value), 10, 32)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid L7 payload size %q: %w", spec, err)
+		}
+		if size >= MaxPayloadSize {
+			return cfg, fmt.Errorf("invalid L7 payload size %q: must be less than %d", spec, MaxPayloadSize)
+		}
+		cfg.PayloadSizes[protocol] = uint32(size)
+	}
+	return cfg, nil
+}
+
+func (c L7Config) protocolConfig(p l7.Protocol) l7ProtocolConfig {
+	res := l7ProtocolConfig{Enabled: 1, PayloadSize: DefaultPayloadSize}
+	if c.Disabled[p] {
+		res.Enabled = 0
+	}
+	if size, ok := c.PayloadSizes[p]; ok {
+		res.PayloadSize = size
+	}
+	return res
+}
+
+func (c L7Config) apply(m *ebpf.Map) error {
+	for _, p := range l7.Protocols {
+		if err := m.Put(uint32(p), c.protocolConfig(p)); err != nil {
+			return fmt.Errorf("failed to configure %s tracing: %w", p, err)
+		}
+	}
+	return nil
+}
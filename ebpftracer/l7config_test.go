@@ -0,0 +1,25 @@
+package ebpftracer
+
+import (
+	"testing"
+
+	"github.com/coroot/coroot-node-agent/ebpftracer/l7"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewL7Config(t *testing.T) {
+	cfg, err := NewL7Config([]string{"mongo", "Kafka"}, []string{"postgres=256", "dns=0"})
+	assert.NoError(t, err)
+	assert.Equal(t, l7ProtocolConfig{Enabled: 0, PayloadSize: DefaultPayloadSize}, cfg.protocolConfig(l7.ProtocolMongo))
+	assert.Equal(t, l7ProtocolConfig{Enabled: 0, PayloadSize: DefaultPayloadSize}, cfg.protocolConfig(l7.ProtocolKafka))
+	assert.Equal(t, l7ProtocolConfig{Enabled: 1, PayloadSize: 256}, cfg.protocolConfig(l7.ProtocolPostgres))
+	assert.Equal(t, l7ProtocolConfig{Enabled: 1, PayloadSize: 0}, cfg.protocolConfig(l7.ProtocolDNS))
+	assert.Equal(t, l7ProtocolConfig{Enabled: 1, PayloadSize: DefaultPayloadSize}, cfg.protocolConfig(l7.ProtocolHTTP))
+
+	_, err = NewL7Config([]string{"grpc"}, nil)
+	assert.Error(t, err)
+	_, err = NewL7Config(nil, []string{"postgres"})
+	assert.Error(t, err)
+	_, err = NewL7Config(nil, []string{"postgres=1024"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,109 @@
+package ebpftracer
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/coroot/coroot-node-agent/health"
+	"github.com/coroot/coroot-node-agent/proc"
+)
+
+const (
+	procPollingInterval  = 10 * time.Second
+	procPollingHeartbeat = "proc_polling"
+)
+
+// procPoller discovers processes, files and TCP sockets by scanning /proc and sends the changes since the previous scan
+// as events. The first scan reports the state the eBPF programs have missed. If eBPF is unavailable, the scans are
+// repeated on an interval instead, so L7 requests and TCP retransmits aren't reported, and short-lived processes
+// and connections may be missed.
+type procPoller struct {
+	pids    map[uint32]bool
+	files   map[file]bool
+	sockets map[sock]bool
+}
+
+func newProcPoller() *procPoller {
+	return &procPoller{pids: map[uint32]bool{}, files: map[file]bool{}, sockets: map[sock]bool{}}
+}
+
+func (p *procPoller) run(ch chan<- Event, stop <-chan struct{}) {
+	ticker := time.NewTicker(procPollingInterval)
+	defer ticker.Stop()
+	health.Heartbeat(procPollingHeartbeat)
+	for {
+		select {
+		case <-stop:
+			health.RemoveHeartbeat(procPollingHeartbeat)
+			return
+		case <-ticker.C:
+			health.Report(procPollingHeartbeat, p.poll(ch))
+			health.Heartbeat(procPollingHeartbeat)
+		}
+	}
+}
+
+func (p *procPoller) poll(ch chan<- Event) error {
+	pids, err := proc.ListPids()
+	if err != nil {
+		return fmt.Errorf("failed to list pids: %w", err)
+	}
+	pidsAlive := make(map[uint32]bool, len(pids))
+	for _, pid := range pids {
+		pidsAlive[pid] = true
+		if !p.pids[pid] {
+			ch <- Event{Type: EventTypeProcessStart, Pid: pid}
+		}
+	}
+
+	fds, sockets := readFds(pids)
+	files := make(map[file]bool, len(fds))
+	for _, fd := range fds {
+		files[fd] = true
+		if !p.files[fd] {
+			ch <- Event{Type: EventTypeFileOpen, Pid: fd.pid, Fd: fd.fd}
+		}
+	}
+	p.files = files
+
+	listens := map[uint64]bool{}
+	for _, s := range sockets {
+		if s.Listen {
+			listens[uint64(s.pid)<<32|uint64(s.SAddr.Port())] = true
+		}
+	}
+	socketsOpen := map[sock]bool{}
+	for _, s := range sockets {
+		if !s.Listen && (listens[uint64(s.pid)<<32|uint64(s.SAddr.Port())] || s.DAddr.Port() > s.SAddr.Port()) { // inbound
+			continue
+		}
+		socketsOpen[s] = true
+		if p.sockets[s] {
+			continue
+		}
+		typ := EventTypeConnectionOpen
+		if s.Listen {
+			typ = EventTypeListenOpen
+		}
+		ch <- Event{Type: typ, Pid: s.pid, Fd: s.fd, SrcAddr: s.SAddr, DstAddr: s.DAddr}
+	}
+	for s := range p.sockets {
+		if socketsOpen[s] {
+			continue
+		}
+		typ := EventTypeConnectionClose
+		if s.Listen {
+			typ = EventTypeListenClose
+		}
+		ch <- Event{Type: typ, Pid: s.pid, Fd: s.fd, SrcAddr: s.SAddr, DstAddr: s.DAddr}
+	}
+	p.sockets = socketsOpen
+
+	for pid := range p.pids {
+		if !pidsAlive[pid] {
+			ch <- Event{Type: EventTypeProcessExit, Pid: pid}
+		}
+	}
+	p.pids = pidsAlive
+	return nil
+}
@@ -0,0 +1,129 @@
+package ebpftracer
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/coroot/coroot-node-agent/proc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"inet.af/netaddr"
+)
+
+// procFixture is a fake /proc. All the processes share the network namespace of the test,
+// so they must have the same net/tcp.
+type procFixture struct {
+	t   *testing.T
+	dir string
+}
+
+type tcpSocket struct {
+	inode  int
+	local  string
+	remote string
+	listen bool
+}
+
+func (f procFixture) process(pid uint32, sockets []tcpSocket, fds map[uint64]string) {
+	dir := filepath.Join(f.dir, strconv.Itoa(int(pid)))
+	require.NoError(f.t, os.RemoveAll(dir))
+	for _, d := range []string{"fd", "net", "ns"} {
+		require.NoError(f.t, os.MkdirAll(filepath.Join(dir, d), 0755))
+	}
+	require.NoError(f.t, os.Symlink("/proc/self/ns/net", filepath.Join(dir, "ns", "net")))
+	for fd, dest := range fds {
+		require.NoError(f.t, os.Symlink(dest, filepath.Join(dir, "fd", strconv.FormatUint(fd, 10))))
+	}
+	tcp := []string{"  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode"}
+	for i, s := range sockets {
+		state, remote := "01", s.remote
+		if s.listen {
+			state, remote = "0A", "0.0.0.0:0"
+		}
+		tcp = append(tcp, fmt.Sprintf("%4d: %s %s %s 00000000:00000000 00:00000000 00000000     0        0 %d 1 0000000000000000 100 0 0 10 0",
+			i, encodeAddr(s.local), encodeAddr(remote), state, s.inode))
+	}
+	require.NoError(f.t, os.WriteFile(filepath.Join(dir, "net", "tcp"), []byte(strings.Join(tcp, "\n")+"\n"), 0644))
+}
+
+func (f procFixture) exit(pid uint32) {
+	require.NoError(f.t, os.RemoveAll(filepath.Join(f.dir, strconv.Itoa(int(pid)))))
+}
+
+func encodeAddr(addr string) string {
+	a := netaddr.MustParseIPPort(addr)
+	ip := a.IP().As4()
+	return fmt.Sprintf("%08X:%04X", binary.LittleEndian.Uint32(ip[:]), a.Port())
+}
+
+func TestProcPoller(t *testing.T) {
+	if _, err := os.Stat("/proc/self/ns/net"); err != nil {
+		t.Skip("network namespaces are not available:", err)
+	}
+	f := procFixture{t: t, dir: t.TempDir()}
+	proc.SetRoot(f.dir)
+	defer proc.SetRoot("/proc")
+
+	listen := tcpSocket{inode: 1001, local: "0.0.0.0:8080", listen: true}
+	accepted := tcpSocket{inode: 1002, local: "10.0.0.1:8080", remote: "10.0.0.2:50000"}
+	outbound := tcpSocket{inode: 1003, local: "10.0.0.1:40000", remote: "10.0.0.3:5432"}
+	inbound := tcpSocket{inode: 1004, local: "10.0.0.1:6379", remote: "10.0.0.4:50001"} // the listener isn't visible to the process
+	outbound2 := tcpSocket{inode: 1005, local: "10.0.0.1:40001", remote: "10.0.0.5:80"}
+	socket := func(s tcpSocket) string { return fmt.Sprintf("socket:[%d]", s.inode) }
+
+	p := newProcPoller()
+	poll := func() []Event {
+		ch := make(chan Event, 100)
+		require.NoError(t, p.poll(ch))
+		close(ch)
+		var res []Event
+		for e := range ch {
+			res = append(res, e)
+		}
+		return res
+	}
+	addr := netaddr.MustParseIPPort
+
+	f.process(100, []tcpSocket{listen, accepted, outbound, inbound}, map[uint64]string{
+		3: "/var/log/app.log",
+		4: socket(listen),
+		5: socket(accepted),
+		6: socket(outbound),
+		7: socket(inbound),
+		8: "pipe:[2001]",
+	})
+	assert.ElementsMatch(t, []Event{
+		{Type: EventTypeProcessStart, Pid: 100},
+		{Type: EventTypeFileOpen, Pid: 100, Fd: 3},
+		{Type: EventTypeListenOpen, Pid: 100, Fd: 4, SrcAddr: addr("0.0.0.0:8080"), DstAddr: addr("0.0.0.0:0")},
+		{Type: EventTypeConnectionOpen, Pid: 100, Fd: 6, SrcAddr: addr("10.0.0.1:40000"), DstAddr: addr("10.0.0.3:5432")},
+	}, poll())
+
+	assert.Empty(t, poll(), "nothing has changed")
+
+	f.process(100, []tcpSocket{listen, accepted, inbound, outbound2}, map[uint64]string{
+		3: "/var/log/app.log",
+		4: socket(listen),
+		5: socket(accepted),
+		7: socket(inbound),
+	})
+	f.process(200, []tcpSocket{listen, accepted, inbound, outbound2}, map[uint64]string{3: socket(outbound2)})
+	assert.ElementsMatch(t, []Event{
+		{Type: EventTypeProcessStart, Pid: 200},
+		{Type: EventTypeConnectionClose, Pid: 100, Fd: 6, SrcAddr: addr("10.0.0.1:40000"), DstAddr: addr("10.0.0.3:5432")},
+		{Type: EventTypeConnectionOpen, Pid: 200, Fd: 3, SrcAddr: addr("10.0.0.1:40001"), DstAddr: addr("10.0.0.5:80")},
+	}, poll())
+
+	f.exit(100)
+	f.process(200, []tcpSocket{outbound2}, map[uint64]string{3: socket(outbound2), 4: "/tmp/data"})
+	assert.ElementsMatch(t, []Event{
+		{Type: EventTypeFileOpen, Pid: 200, Fd: 4},
+		{Type: EventTypeListenClose, Pid: 100, Fd: 4, SrcAddr: addr("0.0.0.0:8080"), DstAddr: addr("0.0.0.0:0")},
+		{Type: EventTypeProcessExit, Pid: 100},
+	}, poll())
+}
@@ -0,0 +1,29 @@
+package ebpftracer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/coroot/coroot-node-agent/health"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSendEventHeartbeats(t *testing.T) {
+	const heartbeat = "perf_reader:test"
+	defer health.RemoveHeartbeat(heartbeat)
+
+	ch := make(chan Event)
+	sent := make(chan struct{})
+	go func() {
+		defer close(sent)
+		sendEvent(ch, Event{Type: EventTypeProcessStart, Pid: 1}, heartbeat, 10*time.Millisecond)
+	}()
+	require.Eventually(t, func() bool {
+		return health.Live(time.Now()).Checks[heartbeat] != nil
+	}, time.Second, 10*time.Millisecond, "the blocked reader reports heartbeats")
+	assert.Equal(t, health.StatusOk, health.Live(time.Now()).Status)
+
+	assert.Equal(t, Event{Type: EventTypeProcessStart, Pid: 1}, <-ch)
+	<-sent
+}
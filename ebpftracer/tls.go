@@ -12,6 +12,7 @@ import (
 	"strings"
 
 	"github.com/cilium/ebpf/link"
+	"github.com/coroot/coroot-node-agent/capabilities"
 	"github.com/coroot/coroot-node-agent/proc"
 	"golang.org/x/arch/arm64/arm64asm"
 	"golang.org/x/arch/x86/x86asm"
@@ -30,7 +31,7 @@ var (
 )
 
 func (t *Tracer) AttachOpenSslUprobes(pid uint32) []link.Link {
-	if t.disableL7Tracing {
+	if t.disableL7Tracing || !capabilities.Enabled(capabilities.FeatureUprobes) {
 		return nil
 	}
 	libPath, version := getSslLibPathAndVersion(pid)
@@ -110,7 +111,7 @@ func (t *Tracer) AttachOpenSslUprobes(pid uint32) []link.Link {
 
 func (t *Tracer) AttachGoTlsUprobes(pid uint32) ([]link.Link, bool) {
 	isGolangApp := false
-	if t.disableL7Tracing {
+	if t.disableL7Tracing || !capabilities.Enabled(capabilities.FeatureUprobes) {
 		return nil, isGolangApp
 	}
 
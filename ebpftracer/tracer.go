@@ -9,13 +9,16 @@ import (
 	"runtime"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/cilium/ebpf"
 	"github.com/cilium/ebpf/link"
 	"github.com/cilium/ebpf/perf"
+	"github.com/coroot/coroot-node-agent/capabilities"
 	"github.com/coroot/coroot-node-agent/common"
 	"github.com/coroot/coroot-node-agent/ebpftracer/l7"
+	"github.com/coroot/coroot-node-agent/health"
 	"github.com/coroot/coroot-node-agent/proc"
 	"golang.org/x/mod/semver"
 	"golang.org/x/sys/unix"
@@ -66,87 +69,144 @@ const (
 
 type Tracer struct {
 	kernelVersion    string
+	disableEbpf      bool
 	disableL7Tracing bool
+	l7Config         L7Config
+
+	poller      *procPoller
+	stopPolling chan struct{}
 
 	collection *ebpf.Collection
 	readers    map[string]*perf.Reader
 	links      []link.Link
 	uprobes    map[string]*ebpf.Program
+	readersWg  sync.WaitGroup
+
+	tracedPids     *ebpf.Map
+	tracedPidsLock sync.RWMutex
 }
 
-func NewTracer(kernelVersion string, disableL7Tracing bool) *Tracer {
+func NewTracer(kernelVersion string, disableEbpf, disableL7Tracing bool, l7Config L7Config) *Tracer {
 	if disableL7Tracing {
 		klog.Infoln("L7 tracing is disabled")
 	}
+	for p := range l7Config.Disabled {
+		klog.Infof("%s tracing is disabled", p)
+	}
 	return &Tracer{
 		kernelVersion:    kernelVersion,
+		disableEbpf:      disableEbpf,
 		disableL7Tracing: disableL7Tracing,
+		l7Config:         l7Config,
 
 		readers: map[string]*perf.Reader{},
 		uprobes: map[string]*ebpf.Program{},
+
+		poller:      newProcPoller(),
+		stopPolling: make(chan struct{}),
 	}
 }
 
+// Run loads the eBPF programs and sends the events of the already running processes.
+// If eBPF is disabled or can't be used, the processes and TCP sockets are discovered by polling /proc instead.
 func (t *Tracer) Run(events chan<- Event) error {
-	if err := t.ebpf(events); err != nil {
-		return err
+	err := errors.New("disabled")
+	if !t.disableEbpf {
+		if err = capabilities.Check(capabilities.FeatureEbpf); err == nil {
+			err = t.ebpf(events)
+		}
+	}
+	health.Report("ebpf", err)
+	fallback := err != nil
+	if fallback {
+		klog.Errorln("eBPF is unavailable:", err)
+		klog.Warningln("falling back to /proc polling, L7 tracing and TCP retransmits are unavailable")
+		health.Optional("ebpf")
+		t.disableL7Tracing = true
 	}
-	if err := t.init(events); err != nil {
+	err = t.poller.poll(events)
+	health.Report("initial_scan", err)
+	if err != nil {
 		return err
 	}
+	if fallback {
+		t.readersWg.Add(1)
+		go func() {
+			defer t.readersWg.Done()
+			t.poller.run(events, t.stopPolling)
+		}()
+	}
 	return nil
 }
 
+// Close detaches the eBPF programs and waits until the events already read from the perf buffers
+// or found by polling /proc are sent to the channel.
 func (t *Tracer) Close() {
-	for _, p := range t.uprobes {
+	close(t.stopPolling)
+	t.closeEbpf()
+}
+
+func (t *Tracer) closeEbpf() {
+	for name, p := range t.uprobes {
 		_ = p.Close()
+		delete(t.uprobes, name)
 	}
 	for _, l := range t.links {
 		_ = l.Close()
 	}
-	for _, r := range t.readers {
+	t.links = nil
+	for name, r := range t.readers {
 		_ = r.Close()
+		delete(t.readers, name)
+	}
+	t.readersWg.Wait()
+	t.tracedPidsLock.Lock()
+	t.tracedPids = nil
+	t.tracedPidsLock.Unlock()
+	if t.collection != nil {
+		t.collection.Close()
+		t.collection = nil
 	}
-	t.collection.Close()
 }
 
-func (t *Tracer) init(ch chan<- Event) error {
-	pids, err := proc.ListPids()
-	if err != nil {
-		return fmt.Errorf("failed to list pids: %w", err)
+// TracePid enables L7 tracing of the process in the kernel, the L7 programs ignore the other processes.
+func (t *Tracer) TracePid(pid uint32) error {
+	t.tracedPidsLock.RLock()
+	defer t.tracedPidsLock.RUnlock()
+	if t.tracedPids == nil {
+		return nil
 	}
-	for _, pid := range pids {
-		ch <- Event{Type: EventTypeProcessStart, Pid: pid}
+	if err := t.tracedPids.Put(pid, uint8(1)); err != nil {
+		return fmt.Errorf("failed to enable tracing of pid %d: %w", pid, err)
 	}
+	return nil
+}
 
-	fds, sockets := readFds(pids)
-	for _, fd := range fds {
-		ch <- Event{Type: EventTypeFileOpen, Pid: fd.pid, Fd: fd.fd}
+func (t *Tracer) UntracePid(pid uint32) {
+	t.tracedPidsLock.RLock()
+	defer t.tracedPidsLock.RUnlock()
+	if t.tracedPids == nil {
+		return
 	}
-
-	listens := map[uint64]bool{}
-	for _, s := range sockets {
-		if s.Listen {
-			listens[uint64(s.pid)<<32|uint64(s.SAddr.Port())] = true
-		}
+	if err := t.tracedPids.Delete(pid); err != nil && !errors.Is(err, ebpf.ErrKeyNotExist) {
+		klog.Warningln("failed to disable tracing of pid", pid, err)
 	}
+}
 
-	for _, s := range sockets {
-		typ := EventTypeConnectionOpen
-		if s.Listen {
-			typ = EventTypeListenOpen
-		} else if listens[uint64(s.pid)<<32|uint64(s.SAddr.Port())] || s.DAddr.Port() > s.SAddr.Port() { // inbound
-			continue
-		}
-		ch <- Event{
-			Type:    typ,
-			Pid:     s.pid,
-			Fd:      s.fd,
-			SrcAddr: s.SAddr,
-			DstAddr: s.DAddr,
-		}
+// sizeTracedPids makes room for all the possible pids in traced_pids, since a pid can't be traced once the map is full.
+func sizeTracedPids(spec *ebpf.CollectionSpec) {
+	m := spec.Maps["traced_pids"]
+	if m == nil {
+		return
+	}
+	pidMax, err := proc.GetPidMax()
+	if err != nil {
+		klog.Warningln("failed to read kernel.pid_max:", err)
+		return
+	}
+	if pidMax > m.MaxEntries {
+		m.MaxEntries = pidMax
 	}
-	return nil
 }
 
 type perfMap struct {
@@ -181,6 +241,7 @@ func (t *Tracer) ebpf(ch chan<- Event) error {
 	if err != nil {
 		return fmt.Errorf("failed to load collection spec: %w", err)
 	}
+	sizeTracedPids(collectionSpec)
 	_ = unix.Setrlimit(unix.RLIMIT_MEMLOCK, &unix.Rlimit{Cur: unix.RLIM_INFINITY, Max: unix.RLIM_INFINITY})
 	c, err := ebpf.NewCollectionWithOptions(collectionSpec, ebpf.CollectionOptions{
 		//Programs: ebpf.ProgramOptions{LogLevel: 2, LogSize: 20 * 1024 * 1024},
@@ -193,6 +254,17 @@ func (t *Tracer) ebpf(ch chan<- Event) error {
 		return fmt.Errorf("failed to load collection: %w", err)
 	}
 	t.collection = c
+	if m := c.Maps["l7_config"]; m != nil {
+		if err = t.l7Config.apply(m); err != nil {
+			t.closeEbpf()
+			return err
+		}
+	} else {
+		klog.Warningln("the eBPF programs don't support the L7 tracing settings")
+	}
+	t.tracedPidsLock.Lock()
+	t.tracedPids = c.Maps["traced_pids"]
+	t.tracedPidsLock.Unlock()
 
 	perfMaps := []perfMap{
 		{name: "proc_events", typ: perfMapTypeProcEvents, perCPUBufferSizePages: 4},
@@ -209,11 +281,15 @@ func (t *Tracer) ebpf(ch chan<- Event) error {
 	for _, pm := range perfMaps {
 		r, err := perf.NewReader(t.collection.Maps[pm.name], pm.perCPUBufferSizePages*os.Getpagesize())
 		if err != nil {
-			t.Close()
+			t.closeEbpf()
 			return fmt.Errorf("failed to create ebpf reader: %w", err)
 		}
 		t.readers[pm.name] = r
-		go runEventsReader(pm.name, r, ch, pm.typ)
+		t.readersWg.Add(1)
+		go func(name string, r *perf.Reader, typ perfMapType) {
+			defer t.readersWg.Done()
+			runEventsReader(name, r, ch, typ)
+		}(pm.name, r, pm.typ)
 	}
 
 	for _, programSpec := range collectionSpec.Programs {
@@ -241,7 +317,7 @@ func (t *Tracer) ebpf(ch chan<- Event) error {
 			l, err = link.Kprobe(programSpec.AttachTo, program, nil)
 		}
 		if err != nil {
-			t.Close()
+			t.closeEbpf()
 			return fmt.Errorf("failed to link program: %w", err)
 		}
 		t.links = append(t.links, l)
@@ -323,10 +399,20 @@ type l7Event struct {
 }
 
 func runEventsReader(name string, r *perf.Reader, ch chan<- Event, typ perfMapType) {
+	heartbeat := "perf_reader:" + name
+	var lastHeartbeat time.Time
 	for {
+		// the deadline makes the reader report heartbeats even if there are no events
+		now := time.Now()
+		if now.Sub(lastHeartbeat) >= health.HeartbeatInterval {
+			health.Heartbeat(heartbeat)
+			lastHeartbeat = now
+		}
+		r.SetDeadline(now.Add(health.HeartbeatInterval))
 		rec, err := r.Read()
 		if err != nil {
 			if errors.Is(err, perf.ErrClosed) {
+				health.RemoveHeartbeat(heartbeat)
 				break
 			}
 			continue
@@ -355,8 +441,8 @@ func runEventsReader(name string, r *perf.Reader, ch chan<- Event, typ perfMapTy
 			}
 			switch {
 			case v.PayloadSize == 0:
-			case v.PayloadSize > MaxPayloadSize:
-				req.Payload = payload[:MaxPayloadSize]
+			case v.PayloadSize > uint64(len(payload)):
+				req.Payload = payload
 			default:
 				req.Payload = payload[:v.PayloadSize]
 			}
@@ -393,7 +479,27 @@ func runEventsReader(name string, r *perf.Reader, ch chan<- Event, typ perfMapTy
 			continue
 		}
 
-		ch <- event
+		sendEvent(ch, event, heartbeat, health.HeartbeatInterval)
+	}
+}
+
+// sendEvent keeps reporting heartbeats while the channel is full: the reader waiting for the events loop under load is alive,
+// and the events loop has its own heartbeat.
+func sendEvent(ch chan<- Event, event Event, heartbeat string, interval time.Duration) {
+	select {
+	case ch <- event:
+		return
+	default:
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case ch <- event:
+			return
+		case <-ticker.C:
+			health.Heartbeat(heartbeat)
+		}
 	}
 }
 
@@ -0,0 +1,74 @@
+package ebpftracer
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cilium/ebpf"
+	"github.com/coroot/coroot-node-agent/proc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func TestSizeTracedPids(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "sys", "kernel"), 0755))
+	proc.SetRoot(dir)
+	defer proc.SetRoot("/proc")
+	spec := func() *ebpf.CollectionSpec {
+		return &ebpf.CollectionSpec{Maps: map[string]*ebpf.MapSpec{"traced_pids": {Type: ebpf.Hash, MaxEntries: 32768}}}
+	}
+
+	s := spec()
+	sizeTracedPids(s)
+	assert.Equal(t, uint32(32768), s.Maps["traced_pids"].MaxEntries, "pid_max is unknown")
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "sys", "kernel", "pid_max"), []byte("4194304\n"), 0644))
+	s = spec()
+	sizeTracedPids(s)
+	assert.Equal(t, uint32(4194304), s.Maps["traced_pids"].MaxEntries)
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "sys", "kernel", "pid_max"), []byte("4096\n"), 0644))
+	s = spec()
+	sizeTracedPids(s)
+	assert.Equal(t, uint32(32768), s.Maps["traced_pids"].MaxEntries, "the map is never shrunk")
+
+	sizeTracedPids(&ebpf.CollectionSpec{}) // old eBPF programs without the map
+}
+
+func TestTracePid(t *testing.T) {
+	tr := NewTracer("", true, false, L7Config{})
+	assert.NoError(t, tr.TracePid(1), "nothing to do without the eBPF programs")
+	tr.UntracePid(1)
+
+	m, err := ebpf.NewMap(&ebpf.MapSpec{Type: ebpf.Hash, KeySize: 4, ValueSize: 1, MaxEntries: 2, Flags: unix.BPF_F_NO_PREALLOC})
+	if err != nil {
+		t.Skip("can't create eBPF maps:", err)
+	}
+	defer m.Close()
+	tr.tracedPids = m
+	traced := func() []uint32 {
+		var res []uint32
+		var pid uint32
+		var v uint8
+		it := m.Iterate()
+		for it.Next(&pid, &v) {
+			res = append(res, pid)
+		}
+		require.NoError(t, it.Err())
+		return res
+	}
+
+	require.NoError(t, tr.TracePid(10))
+	require.NoError(t, tr.TracePid(20))
+	require.NoError(t, tr.TracePid(20))
+	assert.ElementsMatch(t, []uint32{10, 20}, traced())
+	assert.Error(t, tr.TracePid(30), "the map is full")
+
+	tr.UntracePid(10)
+	tr.UntracePid(10)
+	require.NoError(t, tr.TracePid(30))
+	assert.ElementsMatch(t, []uint32{20, 30}, traced())
+}
@@ -0,0 +1,70 @@
+package fileexport
+
+import (
+	"path/filepath"
+
+	"github.com/coroot/coroot-node-agent/flags"
+	"k8s.io/klog/v2"
+)
+
+const (
+	Traces   = "traces"
+	Logs     = "logs"
+	Metrics  = "metrics"
+	Profiles = "profiles"
+)
+
+var writers = map[string]*Writer{}
+
+func Init() error {
+	if !*flags.FileExport {
+		return nil
+	}
+	dir := filepath.Join(*flags.WalDir, "export")
+	klog.Infoln("exporting metrics, traces, logs and profiles to:", dir)
+	maxSize := int64(*flags.FileExportMaxFileSize)
+	maxFiles := *flags.FileExportMaxFiles
+	streams := map[string]string{
+		Traces: "jsonl",
+		Logs:   "jsonl",
+	}
+	for name, ext := range streams {
+		w, err := newWriter(filepath.Join(dir, name), name, ext, maxSize, *flags.FileExportRotationInterval, maxFiles, 0)
+		if err != nil {
+			return err
+		}
+		writers[name] = w
+	}
+	// each metrics snapshot and profile is written to a separate file, so these directories are limited by their total size
+	separate := map[string]string{
+		Metrics:  "txt",
+		Profiles: "pb.gz",
+	}
+	for name, ext := range separate {
+		w, err := newWriter(filepath.Join(dir, name), name, ext, 0, 0, 0, maxSize*int64(maxFiles))
+		if err != nil {
+			return err
+		}
+		writers[name] = w
+	}
+	return nil
+}
+
+func Enabled() bool {
+	return len(writers) > 0
+}
+
+func GetWriter(name string) *Writer {
+	return writers[name]
+}
+
+// Close stops writing the metrics snapshots and closes the files.
+func Close() {
+	close(stopMetrics)
+	metricsDone.Wait()
+	for name, w := range writers {
+		if err := w.Close(); err != nil {
+			klog.Warningln("failed to close", name, "export file:", err)
+		}
+	}
+}
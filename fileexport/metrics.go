@@ -0,0 +1,65 @@
+package fileexport
+
+import (
+	"bytes"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+	"k8s.io/klog/v2"
+)
+
+var (
+	stopMetrics = make(chan struct{})
+	metricsDone sync.WaitGroup
+)
+
+// StartMetrics periodically writes OpenMetrics snapshots of the gathered metrics.
+// Each snapshot is a separate file terminated by "# EOF", and every sample carries the snapshot timestamp.
+func StartMetrics(g prometheus.Gatherer, interval time.Duration) {
+	w := GetWriter(Metrics)
+	if w == nil {
+		return
+	}
+	metricsDone.Add(1)
+	go func() {
+		defer metricsDone.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stopMetrics:
+				return
+			case t := <-ticker.C:
+				if err := writeMetricsSnapshot(w, g, t); err != nil {
+					klog.Errorln("failed to write metrics snapshot:", err)
+				}
+			}
+		}
+	}()
+}
+
+func writeMetricsSnapshot(w *Writer, g prometheus.Gatherer, t time.Time) error {
+	mfs, err := g.Gather()
+	if err != nil {
+		klog.Warningln(err)
+	}
+	ts := t.UnixMilli()
+	buf := bytes.NewBuffer(nil)
+	enc := expfmt.NewEncoder(buf, expfmt.FmtOpenMetrics_1_0_0)
+	for _, mf := range mfs {
+		for _, m := range mf.Metric {
+			m.TimestampMs = &ts
+		}
+		if err = enc.Encode(mf); err != nil {
+			return err
+		}
+	}
+	if closer, ok := enc.(expfmt.Closer); ok {
+		if err = closer.Close(); err != nil {
+			return err
+		}
+	}
+	return w.WriteFile("", buf.Bytes())
+}
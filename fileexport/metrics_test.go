@@ -0,0 +1,40 @@
+package fileexport
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteMetricsSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	w, err := newWriter(dir, "metrics", "txt", 0, 0, 0, 0)
+	require.NoError(t, err)
+	defer w.Close()
+
+	reg := prometheus.NewRegistry()
+	c := prometheus.NewCounter(prometheus.CounterOpts{Name: "node_agent_test_total"})
+	reg.MustRegister(c)
+
+	t1 := time.Unix(1700000000, 0)
+	require.NoError(t, writeMetricsSnapshot(w, reg, t1))
+	c.Inc()
+	require.NoError(t, writeMetricsSnapshot(w, reg, t1.Add(time.Minute)))
+
+	files, err := w.files()
+	require.NoError(t, err)
+	require.Len(t, files, 2, "each snapshot is a separate file")
+	for i, f := range files {
+		data, err := os.ReadFile(filepath.Join(dir, f.name))
+		require.NoError(t, err)
+		assert.Equal(t, 1, strings.Count(string(data), "# EOF"))
+		assert.True(t, strings.HasSuffix(string(data), "# EOF\n"))
+		assert.Contains(t, string(data), "node_agent_test_total "+[]string{"0", "1"}[i])
+	}
+}
@@ -0,0 +1,47 @@
+package fileexport
+
+import (
+	"context"
+
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// OtlpClient writes OTLP export requests as JSON lines (the format of the OpenTelemetry Collector file exporter).
+// It implements both otlptrace.Client and otlplogs.Client.
+type OtlpClient struct {
+	w *Writer
+}
+
+func NewOtlpClient(w *Writer) *OtlpClient {
+	return &OtlpClient{w: w}
+}
+
+func (c *OtlpClient) Start(ctx context.Context) error {
+	return nil
+}
+
+func (c *OtlpClient) Stop(ctx context.Context) error {
+	return nil
+}
+
+func (c *OtlpClient) UploadTraces(ctx context.Context, protoSpans []*tracepb.ResourceSpans) error {
+	return c.write(&coltracepb.ExportTraceServiceRequest{ResourceSpans: protoSpans})
+}
+
+func (c *OtlpClient) UploadLogs(ctx context.Context, protoLogs []*logspb.ResourceLogs) error {
+	return c.write(&collogspb.ExportLogsServiceRequest{ResourceLogs: protoLogs})
+}
+
+func (c *OtlpClient) write(req proto.Message) error {
+	data, err := protojson.Marshal(req)
+	if err != nil {
+		return err
+	}
+	_, err = c.w.Write(append(data, '\n'))
+	return err
+}
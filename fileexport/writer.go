@@ -0,0 +1,188 @@
+package fileexport
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+const timestampLayout = "20060102T150405.000000000Z"
+
+// Writer appends data to files named <prefix>-<timestamp>.<ext> in a directory.
+// The current file is rotated when it reaches maxSize or gets older than maxAge.
+// The oldest files are deleted once there are more than maxFiles of them or their total size exceeds maxTotalSize.
+type Writer struct {
+	dir          string
+	prefix       string
+	ext          string
+	maxSize      int64
+	maxAge       time.Duration
+	maxFiles     int
+	maxTotalSize int64
+
+	lock     sync.Mutex
+	f        *os.File
+	size     int64
+	openedAt time.Time
+	closed   bool
+}
+
+func newWriter(dir, prefix, ext string, maxSize int64, maxAge time.Duration, maxFiles int, maxTotalSize int64) (*Writer, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	w := &Writer{
+		dir:          dir,
+		prefix:       prefix,
+		ext:          ext,
+		maxSize:      maxSize,
+		maxAge:       maxAge,
+		maxFiles:     maxFiles,
+		maxTotalSize: maxTotalSize,
+	}
+	return w, nil
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.closed {
+		return 0, os.ErrClosed
+	}
+	now := time.Now()
+	if w.f != nil && w.shouldRotate(now, int64(len(p))) {
+		w.close()
+	}
+	created := false
+	if w.f == nil {
+		f, err := w.create(now, "")
+		if err != nil {
+			return 0, err
+		}
+		w.f = f
+		w.size = 0
+		w.openedAt = now
+		created = true
+	}
+	n, err := w.f.Write(p)
+	w.size += int64(n)
+	if created {
+		// the old files are deleted only once the new one has data to replace them
+		w.prune()
+	}
+	return n, err
+}
+
+// WriteFile stores the data as a separate file, the suffix is appended to the file name.
+func (w *Writer) WriteFile(suffix string, data []byte) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.closed {
+		return os.ErrClosed
+	}
+	f, err := w.create(time.Now(), suffix)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(data); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	w.prune()
+	return nil
+}
+
+// Close closes the current file, the subsequent writes fail instead of creating a new one.
+func (w *Writer) Close() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.closed = true
+	return w.close()
+}
+
+func (w *Writer) shouldRotate(now time.Time, size int64) bool {
+	if w.maxAge > 0 && now.Sub(w.openedAt) >= w.maxAge {
+		return true
+	}
+	return w.maxSize > 0 && w.size > 0 && w.size+size > w.maxSize
+}
+
+func (w *Writer) close() error {
+	if w.f == nil {
+		return nil
+	}
+	err := w.f.Close()
+	w.f = nil
+	return err
+}
+
+func (w *Writer) create(now time.Time, suffix string) (*os.File, error) {
+	name := w.prefix + "-" + now.UTC().Format(timestampLayout)
+	if suffix != "" {
+		name += "-" + suffix
+	}
+	name += "." + w.ext
+	return os.OpenFile(filepath.Join(w.dir, name), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+}
+
+func (w *Writer) prune() {
+	if w.maxFiles <= 0 && w.maxTotalSize <= 0 {
+		return
+	}
+	files, err := w.files()
+	if err != nil {
+		klog.Warningln(err)
+		return
+	}
+	var total int64
+	for _, f := range files {
+		total += f.size
+	}
+	for len(files) > 1 {
+		if (w.maxFiles <= 0 || len(files) <= w.maxFiles) && (w.maxTotalSize <= 0 || total <= w.maxTotalSize) {
+			break
+		}
+		if err = os.Remove(filepath.Join(w.dir, files[0].name)); err != nil && !os.IsNotExist(err) {
+			klog.Warningln(err)
+		}
+		total -= files[0].size
+		files = files[1:]
+	}
+}
+
+type file struct {
+	name string
+	size int64
+}
+
+func (w *Writer) files() ([]file, error) {
+	entries, err := os.ReadDir(w.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", w.dir, err)
+	}
+	var res []file
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasPrefix(name, w.prefix+"-") || !strings.HasSuffix(name, "."+w.ext) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		res = append(res, file{name: name, size: info.Size()})
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].name < res[j].name
+	})
+	return res, nil
+}
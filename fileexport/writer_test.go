@@ -0,0 +1,67 @@
+package fileexport
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriterRotation(t *testing.T) {
+	dir := t.TempDir()
+	w, err := newWriter(dir, "traces", "jsonl", 10, time.Hour, 2, 0)
+	require.NoError(t, err)
+	defer w.Close()
+
+	for _, line := range []string{"aaaa\n", "bbbb\n", "cccc\n", "dddd\n", "eeee\n"} {
+		_, err = w.Write([]byte(line))
+		require.NoError(t, err)
+	}
+	files, err := w.files()
+	require.NoError(t, err)
+	require.Len(t, files, 2)
+	data, err := os.ReadFile(dir + "/" + files[1].name)
+	require.NoError(t, err)
+	assert.Equal(t, "eeee\n", string(data))
+
+	w.openedAt = time.Now().Add(-2 * time.Hour)
+	_, err = w.Write([]byte("f\n"))
+	require.NoError(t, err)
+	files, err = w.files()
+	require.NoError(t, err)
+	data, err = os.ReadFile(dir + "/" + files[len(files)-1].name)
+	require.NoError(t, err)
+	assert.Equal(t, "f\n", string(data))
+}
+
+func TestWriterTotalSize(t *testing.T) {
+	dir := t.TempDir()
+	w, err := newWriter(dir, "profiles", "pb.gz", 0, 0, 0, 10)
+	require.NoError(t, err)
+
+	for _, suffix := range []string{"a", "b", "c", "d"} {
+		require.NoError(t, w.WriteFile(suffix, []byte("1234")))
+	}
+	files, err := w.files()
+	require.NoError(t, err)
+	assert.Len(t, files, 2, "the new file is counted with its data")
+	assert.Contains(t, files[0].name, "-c.pb.gz")
+}
+
+func TestWriterClosed(t *testing.T) {
+	dir := t.TempDir()
+	w, err := newWriter(dir, "metrics", "txt", 0, 0, 0, 0)
+	require.NoError(t, err)
+	_, err = w.Write([]byte("a\n"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	_, err = w.Write([]byte("b\n"))
+	assert.ErrorIs(t, err, os.ErrClosed)
+	assert.ErrorIs(t, w.WriteFile("c", []byte("c")), os.ErrClosed)
+	files, err := w.files()
+	require.NoError(t, err)
+	assert.Len(t, files, 1, "a write after Close doesn't create a new file")
+}
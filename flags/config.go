@@ -0,0 +1,366 @@
+package flags
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"os/signal"
+	"reflect"
+	"regexp"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+	"gopkg.in/yaml.v2"
+	"k8s.io/klog/v2"
+)
+
+const configCheckInterval = 10 * time.Second
+
+// reloadable lists the flags that are applied on SIGHUP or config file change without restarting the agent.
+// Changing any other flag in the config file requires a restart.
+var reloadable = map[string]bool{
+	"track-public-network":  true,
+	"ephemeral-port-range":  true,
+	"log-per-second":        true,
+	"log-burst":             true,
+	"collector-endpoint":    true,
+	"api-key":               true,
+	"metrics-endpoint":      true,
+	"traces-endpoint":       true,
+	"logs-endpoint":         true,
+	"profiles-endpoint":     true,
+	"remote-write-config":   true,
+	"metric-relabel-config": true,
+
+	"max-dns-domains-per-container":     true,
+	"max-l7-destinations-per-container": true,
+	"max-log-patterns-per-container":    true,
+	"max-series":                        true,
+	"max-ip2fqdn-entries":               true,
+}
+
+var envarSplitter = regexp.MustCompile(`\r?\n`)
+
+type reloadHook struct {
+	f     func()
+	flags []string
+}
+
+// valuesLock guards the values of the reloadable flags, which Reload changes while the agent is running.
+var valuesLock sync.RWMutex
+
+var (
+	configLock       sync.Mutex
+	configValues     map[string][]string
+	configModTime    time.Time
+	commandLineFlags = map[string]bool{}
+	reloadHooks      []reloadHook
+)
+
+// OnReload registers a function called after the config is reloaded if any of the given flags has changed.
+// If no flags are given, the function is called on every reload.
+func OnReload(f func(), flags ...string) {
+	configLock.Lock()
+	defer configLock.Unlock()
+	reloadHooks = append(reloadHooks, reloadHook{f: f, flags: flags})
+}
+
+// Get returns the current value of a flag.
+// The reloadable flags must be read through Get, since Reload may change them concurrently.
+func Get[T any](f *T) T {
+	valuesLock.RLock()
+	defer valuesLock.RUnlock()
+	return *f
+}
+
+// WatchConfig reloads the config on SIGHUP or when the config file is modified.
+func WatchConfig() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		ticker := time.NewTicker(configCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-hup:
+				klog.Infoln("SIGHUP received, reloading the config")
+				Reload()
+			case <-ticker.C:
+				if *ConfigFile == "" {
+					continue
+				}
+				configLock.Lock()
+				modified := configModified()
+				configLock.Unlock()
+				if modified {
+					klog.Infoln("the config file has changed, reloading")
+					Reload()
+				}
+			}
+		}
+	}()
+}
+
+// Reload re-reads the config file, applies the reloadable flags and runs the hooks.
+func Reload() {
+	configLock.Lock()
+	values, err := readConfigFile(*ConfigFile)
+	if err != nil {
+		configLock.Unlock()
+		klog.Errorln("failed to reload the config:", err)
+		return
+	}
+	models := flagModels()
+	before := map[string]string{}
+	for name := range reloadable {
+		if f := models[name]; f != nil {
+			before[name] = f.Value.String()
+		}
+	}
+	valuesLock.Lock()
+	for _, name := range sortedKeys(reloadable) {
+		f := models[name]
+		if f == nil || commandLineFlags[name] {
+			continue
+		}
+		if v, ok := values[name]; ok {
+			err = setFlag(f, v)
+		} else {
+			err = resetFlag(f)
+		}
+		if err != nil {
+			klog.Errorf("failed to apply %s: %s", name, err)
+		}
+	}
+	setDefaultEndpoints()
+	valuesLock.Unlock()
+	for _, name := range sortedKeys(values, configValues) {
+		if !reloadable[name] && !commandLineFlags[name] && !reflect.DeepEqual(values[name], configValues[name]) {
+			klog.Warningf("changing %s requires restarting the agent", name)
+		}
+	}
+	configValues = values
+
+	changed := map[string]bool{}
+	for name, v := range before {
+		if models[name].Value.String() != v {
+			klog.Infof("%s: %s -> %s", name, v, models[name].Value.String())
+			changed[name] = true
+		}
+	}
+	var hooks []func()
+	for _, h := range reloadHooks {
+		if len(h.flags) == 0 {
+			hooks = append(hooks, h.f)
+			continue
+		}
+		for _, name := range h.flags {
+			if changed[name] {
+				hooks = append(hooks, h.f)
+				break
+			}
+		}
+	}
+	configLock.Unlock()
+	for _, h := range hooks {
+		h()
+	}
+}
+
+// loadConfig applies the config file on top of the environment variables and defaults.
+// The flags passed on the command line take precedence over the config file.
+func loadConfig(args []string) error {
+	ctx, err := kingpin.CommandLine.ParseContext(args)
+	if err != nil {
+		return err
+	}
+	for _, e := range ctx.Elements {
+		if f, ok := e.Clause.(*kingpin.FlagClause); ok {
+			commandLineFlags[f.Model().Name] = true
+		}
+	}
+	if *ConfigFile == "" {
+		return nil
+	}
+	configLock.Lock()
+	defer configLock.Unlock()
+	values, err := readConfigFile(*ConfigFile)
+	if err != nil {
+		return err
+	}
+	models := flagModels()
+	valuesLock.Lock()
+	defer valuesLock.Unlock()
+	for _, name := range sortedKeys(values) {
+		if commandLineFlags[name] {
+			continue
+		}
+		if err = setFlag(models[name], values[name]); err != nil {
+			return fmt.Errorf("invalid %s in %s: %w", name, *ConfigFile, err)
+		}
+	}
+	configValues = values
+	return nil
+}
+
+func readConfigFile(path string) (map[string][]string, error) {
+	if path == "" {
+		return nil, nil
+	}
+	if info, err := os.Stat(path); err == nil {
+		configModTime = info.ModTime()
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	values, err := parseConfig(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	models := flagModels()
+	for name := range values {
+		if models[name] == nil || name == "config" {
+			return nil, fmt.Errorf("unknown option %q in %s", name, path)
+		}
+	}
+	return values, nil
+}
+
+func configModified() bool {
+	info, err := os.Stat(*ConfigFile)
+	if err != nil {
+		return false
+	}
+	return !info.ModTime().Equal(configModTime)
+}
+
+// parseConfig converts the YAML config keyed by the flag names to the flag values.
+// A list is accepted for the flags that can be specified multiple times.
+func parseConfig(data []byte) (map[string][]string, error) {
+	var raw map[string]interface{}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	res := map[string][]string{}
+	for name, v := range raw {
+		switch vv := v.(type) {
+		case nil:
+			res[name] = nil
+		case []interface{}:
+			var values []string
+			for _, i := range vv {
+				s, err := scalar(i)
+				if err != nil {
+					return nil, fmt.Errorf("%s: %w", name, err)
+				}
+				values = append(values, s)
+			}
+			res[name] = values
+		default:
+			s, err := scalar(v)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+			res[name] = []string{s}
+		}
+	}
+	return res, nil
+}
+
+func scalar(v interface{}) (string, error) {
+	switch v.(type) {
+	case string, bool, int, int64, uint64, float64:
+		return fmt.Sprint(v), nil
+	}
+	return "", fmt.Errorf("unsupported value: %v", v)
+}
+
+type cumulative interface {
+	IsCumulative() bool
+}
+
+func isCumulative(f *kingpin.FlagModel) bool {
+	c, ok := f.Value.(cumulative)
+	return ok && c.IsCumulative()
+}
+
+func setFlag(f *kingpin.FlagModel, values []string) error {
+	if isCumulative(f) {
+		if g, ok := f.Value.(kingpin.Getter); ok {
+			s := reflect.ValueOf(g.Get())
+			if s.Kind() == reflect.Ptr && s.Elem().Kind() == reflect.Slice {
+				s.Elem().Set(reflect.Zero(s.Elem().Type()))
+			}
+		}
+		for _, v := range values {
+			if err := f.Value.Set(v); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	switch len(values) {
+	case 0:
+		return resetFlag(f)
+	case 1:
+		return f.Value.Set(values[0])
+	}
+	return fmt.Errorf("a single value expected, got %d", len(values))
+}
+
+// resetFlag sets the value from the environment variable or the default.
+func resetFlag(f *kingpin.FlagModel) error {
+	if f.Envar != "" {
+		if v := os.Getenv(f.Envar); v != "" {
+			if isCumulative(f) {
+				return setFlag(f, envarSplitter.Split(v, -1))
+			}
+			return f.Value.Set(v)
+		}
+	}
+	if len(f.Default) > 0 || isCumulative(f) {
+		return setFlag(f, f.Default)
+	}
+	if u := urlFlags()[f.Name]; u != nil {
+		*u = nil
+		return nil
+	}
+	return f.Value.Set("")
+}
+
+func urlFlags() map[string]**url.URL {
+	return map[string]**url.URL{
+		"collector-endpoint": CollectorEndpoint,
+		"metrics-endpoint":   MetricsEndpoint,
+		"traces-endpoint":    TracesEndpoint,
+		"logs-endpoint":      LogsEndpoint,
+		"profiles-endpoint":  ProfilesEndpoint,
+	}
+}
+
+func flagModels() map[string]*kingpin.FlagModel {
+	res := map[string]*kingpin.FlagModel{}
+	for _, f := range kingpin.CommandLine.Model().Flags {
+		res[f.Name] = f
+	}
+	return res
+}
+
+func sortedKeys[V any](maps ...map[string]V) []string {
+	seen := map[string]bool{}
+	var res []string
+	for _, m := range maps {
+		for k := range m {
+			if !seen[k] {
+				seen[k] = true
+				res = append(res, k)
+			}
+		}
+	}
+	sort.Strings(res)
+	return res
+}
@@ -0,0 +1,167 @@
+package flags
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseConfig(t *testing.T) {
+	values, err := parseConfig([]byte(`
+listen: 127.0.0.1:10300
+track-public-network:
+  - 10.0.0.0/8
+  - 192.168.0.0/16
+log-per-second: 10.5
+native-histograms: true
+max-series: 10000
+metrics-endpoint:
+`))
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]string{
+		"listen":               {"127.0.0.1:10300"},
+		"track-public-network": {"10.0.0.0/8", "192.168.0.0/16"},
+		"log-per-second":       {"10.5"},
+		"native-histograms":    {"true"},
+		"max-series":           {"10000"},
+		"metrics-endpoint":     nil,
+	}, values)
+
+	_, err = parseConfig([]byte("listen: {host: 127.0.0.1}"))
+	assert.Error(t, err)
+
+	_, err = parseConfig([]byte("listen: [127.0.0.1"))
+	assert.Error(t, err)
+}
+
+func writeConfig(t *testing.T, path, data string) {
+	require.NoError(t, os.WriteFile(path, []byte(data), 0644))
+	// the modification time may not change within the filesystem timestamp granularity
+	configModTime = time.Time{}
+}
+
+// resetFlags sets the defaults since kingpin doesn't parse the command line in tests.
+func resetFlags(t *testing.T) {
+	models := flagModels()
+	for name := range reloadable {
+		require.NoError(t, resetFlag(models[name]))
+	}
+	require.NoError(t, resetFlag(models["listen"]))
+}
+
+func useConfig(t *testing.T, args []string, data string) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig(t, path, data)
+	resetFlags(t)
+	*ConfigFile = path
+	t.Cleanup(func() {
+		*ConfigFile = ""
+		configValues = nil
+		commandLineFlags = map[string]bool{}
+		reloadHooks = nil
+		resetFlags(t)
+	})
+	require.NoError(t, loadConfig(args))
+}
+
+func TestLoadConfig(t *testing.T) {
+	useConfig(t, []string{"--max-series=5"}, `
+listen: 127.0.0.1:10300
+log-per-second: 7
+max-series: 100
+track-public-network: [10.0.0.0/8, 192.168.0.0/16]
+`)
+	assert.Equal(t, "127.0.0.1:10300", *ListenAddress)
+	assert.Equal(t, 7.0, *LogPerSecond)
+	assert.Equal(t, []string{"10.0.0.0/8", "192.168.0.0/16"}, *ExternalNetworksWhitelist)
+	assert.Equal(t, 100000, *MaxSeries, "the command-line flags take precedence over the config file")
+
+	writeConfig(t, *ConfigFile, "unknown-option: 1")
+	assert.ErrorContains(t, loadConfig(nil), `unknown option "unknown-option"`)
+
+	writeConfig(t, *ConfigFile, "log-per-second: fast")
+	assert.ErrorContains(t, loadConfig(nil), "invalid log-per-second")
+
+	writeConfig(t, *ConfigFile, "listen: [127.0.0.1:80, 127.0.0.1:81]")
+	assert.ErrorContains(t, loadConfig(nil), "a single value expected")
+}
+
+func TestReload(t *testing.T) {
+	useConfig(t, nil, `
+listen: 127.0.0.1:10300
+log-per-second: 7
+`)
+	var logsReloaded, metricsReloaded, reloaded int
+	OnReload(func() { logsReloaded++ }, "log-per-second", "log-burst")
+	OnReload(func() { metricsReloaded++ }, "metrics-endpoint")
+	OnReload(func() { reloaded++ })
+
+	writeConfig(t, *ConfigFile, `
+listen: 0.0.0.0:8080
+log-per-second: 8
+`)
+	Reload()
+	assert.Equal(t, 8.0, *LogPerSecond)
+	assert.Equal(t, "127.0.0.1:10300", *ListenAddress, "listen is applied only on restart")
+	assert.Equal(t, 1, logsReloaded)
+	assert.Equal(t, 0, metricsReloaded)
+	assert.Equal(t, 1, reloaded)
+
+	writeConfig(t, *ConfigFile, `
+listen: 0.0.0.0:8080
+metrics-endpoint: http://127.0.0.1:8080/v1/metrics
+`)
+	Reload()
+	assert.Equal(t, 10.0, *LogPerSecond, "a removed option is reset to the default")
+	require.NotNil(t, *MetricsEndpoint)
+	assert.Equal(t, "http://127.0.0.1:8080/v1/metrics", (*MetricsEndpoint).String())
+	assert.Equal(t, 2, logsReloaded)
+	assert.Equal(t, 1, metricsReloaded)
+	assert.Equal(t, 2, reloaded)
+
+	writeConfig(t, *ConfigFile, "log-per-second: [1")
+	Reload()
+	assert.Equal(t, 10.0, *LogPerSecond, "an invalid config is not applied")
+	assert.Equal(t, 2, reloaded)
+}
+
+func TestReloadConcurrentReads(t *testing.T) {
+	useConfig(t, nil, "")
+
+	done := make(chan struct{})
+	read := make(chan struct{})
+	go func() {
+		defer close(read)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				_ = Get(ApiKey)
+				_ = Get(MaxSeries)
+				if u := Get(MetricsEndpoint); u != nil {
+					_ = u.String()
+				}
+				_ = Get(ExternalNetworksWhitelist)
+			}
+		}
+	}()
+	for i := 0; i < 20; i++ {
+		writeConfig(t, *ConfigFile, fmt.Sprintf(`
+api-key: key%d
+max-series: %d
+metrics-endpoint: http://127.0.0.%d:8080/v1/metrics
+track-public-network: [10.0.0.%d/32]
+`, i, i+1, i+1, i))
+		Reload()
+	}
+	close(done)
+	<-read
+	assert.Equal(t, "key19", Get(ApiKey))
+	assert.Equal(t, 20, Get(MaxSeries))
+}
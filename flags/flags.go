@@ -8,13 +8,21 @@ import (
 )
 
 var (
+	ConfigFile = kingpin.Flag("config", "Path to a YAML config file with the options below keyed by their names (e.g., `listen: 0.0.0.0:80`), command-line flags take precedence over it").Envar("CONFIG").String()
+
 	ListenAddress     = kingpin.Flag("listen", "Listen address - ip:port or :port").Default("0.0.0.0:80").Envar("LISTEN").String()
 	CgroupRoot        = kingpin.Flag("cgroupfs-root", "The mount point of the host cgroupfs root").Default("/sys/fs/cgroup").Envar("CGROUPFS_ROOT").String()
 	DisableLogParsing = kingpin.Flag("disable-log-parsing", "Disable container log parsing").Default("false").Envar("DISABLE_LOG_PARSING").Bool()
 	DisablePinger     = kingpin.Flag("disable-pinger", "Don't ping upstreams").Default("false").Envar("DISABLE_PINGER").Bool()
+	DisableEbpf       = kingpin.Flag("disable-ebpf", "Don't load eBPF programs, discover processes and TCP connections by polling /proc (L7 tracing and TCP retransmits are unavailable)").Default("false").Envar("DISABLE_EBPF").Bool()
 	DisableL7Tracing  = kingpin.Flag("disable-l7-tracing", "Disable L7 tracing").Default("false").Envar("DISABLE_L7_TRACING").Bool()
 	LibvirtURI        = kingpin.Flag("libvirt.uri", "Libvirt URI from which to extract metrics.").Default("qemu:///system").Envar("LIBVIRT_URI").String()
 
+	WebConfigFile       = kingpin.Flag("web-config-file", "Path to a web config file (Prometheus exporter-toolkit format) enabling TLS, basic auth or client certificate verification for the listener").Envar("WEB_CONFIG_FILE").String()
+	BearerTokenFile     = kingpin.Flag("web-bearer-token-file", "Path to a file containing the bearer token required to access the listener").Envar("WEB_BEARER_TOKEN_FILE").String()
+	HealthListenAddress = kingpin.Flag("health-listen", "Listen address for the /healthz and /readyz probes served without TLS and authentication, e.g., if the web config requires basic auth (empty to serve them only by the main listener)").Envar("HEALTH_LISTEN").String()
+	DebugListenAddress  = kingpin.Flag("debug-listen", "Listen address for pprof and debug endpoints, must be a loopback address (empty to disable)").Default("127.0.0.1:10301").Envar("DEBUG_LISTEN").String()
+
 	ExternalNetworksWhitelist = kingpin.
 					Flag("track-public-network", "Allow track connections to the specified IP networks, all private networks are allowed by default (e.g., Y.Y.Y.Y/mask)").
 					Envar("TRACK_PUBLIC_NETWORK").
@@ -22,6 +30,9 @@ var (
 					Strings()
 	EphemeralPortRange = kingpin.Flag("ephemeral-port-range", "Destination and Listen TCP ports from this range will be skipped").Default("32768-60999").Envar("EPHEMERAL_PORT_RANGE").String()
 
+	ContainerInclude = kingpin.Flag("container-include", "Track only the containers matching the rule, e.g., namespace=prod*,label:app=web (can be specified multiple times)").Envar("CONTAINER_INCLUDE").Strings()
+	ContainerExclude = kingpin.Flag("container-exclude", "Ignore the containers matching the rule, the fields are id, namespace, image, cgroup, systemd_unit, label:<key> and annotation:<key>, patterns may contain * (can be specified multiple times)").Envar("CONTAINER_EXCLUDE").Strings()
+
 	Provider          = kingpin.Flag("provider", "`provider` label for `node_cloud_info` metric").Envar("PROVIDER").String()
 	Region            = kingpin.Flag("region", "`region` label for `node_cloud_info` metric").Envar("REGION").String()
 	AvailabilityZone  = kingpin.Flag("availability-zone", "`availability_zone` label for `node_cloud_info` metric").Envar("AVAILABILITY_ZONE").String()
@@ -30,6 +41,20 @@ var (
 	LogPerSecond      = kingpin.Flag("log-per-second", "The number of logs per second").Default("10.0").Envar("LOG_PER_SECOND").Float64()
 	LogBurst          = kingpin.Flag("log-burst", "The maximum number of tokens that can be consumed in a single call to allow").Default("100").Envar("LOG_BURST").Int()
 
+	CpuBudget    = kingpin.Flag("cpu-budget", "The CPU budget of the agent in cores, when it is exceeded the agent sheds trace sampling, L7 parsing of the noisiest containers, log parsing and profiling in this order (0 means no limit)").Default("0").Envar("CPU_BUDGET").Float64()
+	MemoryBudget = kingpin.Flag("memory-budget", "The memory budget of the agent (the live heap), when it is exceeded the agent sheds load in the same order as for the CPU budget; it is also set as the soft memory limit of the Go runtime (0 means no limit)").Default("0").Envar("MEMORY_BUDGET").Bytes()
+
+	MaxDNSDomainsPerContainer     = kingpin.Flag("max-dns-domains-per-container", "The maximum number of DNS domains tracked per container, the rest are reported as `__other__` (0 means no limit)").Default("500").Envar("MAX_DNS_DOMAINS_PER_CONTAINER").Int()
+	MaxL7DestinationsPerContainer = kingpin.Flag("max-l7-destinations-per-container", "The maximum number of L7 destinations tracked per container, the rest are reported as `__other__` (0 means no limit)").Default("500").Envar("MAX_L7_DESTINATIONS_PER_CONTAINER").Int()
+	MaxLogPatternsPerContainer    = kingpin.Flag("max-log-patterns-per-container", "The maximum number of log patterns tracked per container, the rest are reported as `__other__` (0 means no limit)").Default("500").Envar("MAX_LOG_PATTERNS_PER_CONTAINER").Int()
+	MaxSeries                     = kingpin.Flag("max-series", "The maximum total number of DNS, L7 and log pattern series of all containers (0 means no limit)").Default("100000").Envar("MAX_SERIES").Int()
+	MaxIp2FqdnEntries             = kingpin.Flag("max-ip2fqdn-entries", "The maximum number of IP to FQDN mappings (0 means no limit)").Default("10000").Envar("MAX_IP2FQDN_ENTRIES").Int()
+
+	L7LatencyBuckets   = kingpin.Flag("l7-latency-buckets", "Latency histogram buckets in seconds for an L7 protocol, e.g., redis=0.0001,0.0005,0.001,0.01 (can be specified multiple times)").Envar("L7_LATENCY_BUCKETS").Strings()
+	DisableL7Protocols = kingpin.Flag("disable-l7-protocol", "Disable tracing of an L7 protocol, e.g., mongo (can be specified multiple times)").Envar("DISABLE_L7_PROTOCOLS").Strings()
+	L7PayloadSizes     = kingpin.Flag("l7-payload-size", "The number of bytes of the request payload captured for an L7 protocol (up to 1023, which is the default), e.g., postgres=256 (can be specified multiple times)").Envar("L7_PAYLOAD_SIZES").Strings()
+	NativeHistograms   = kingpin.Flag("native-histograms", "Expose L7 latency as Prometheus native histograms and remote-write them as native histograms").Default("false").Envar("NATIVE_HISTOGRAMS").Bool()
+
 	CollectorEndpoint = kingpin.Flag("collector-endpoint", "A base endpoint URL for metrics, traces, logs, and profiles").Envar("COLLECTOR_ENDPOINT").URL()
 	ApiKey            = kingpin.Flag("api-key", "Coroot API key").Envar("API_KEY").String()
 	MetricsEndpoint   = kingpin.Flag("metrics-endpoint", "The URL of the endpoint to send metrics to").Envar("METRICS_ENDPOINT").URL()
@@ -39,6 +64,16 @@ var (
 
 	ScrapeInterval = kingpin.Flag("scrape-interval", "How often to gather metrics from the agent").Default("15s").Envar("SCRAPE_INTERVAL").Duration()
 	WalDir         = kingpin.Flag("wal-dir", "Path to where the agent stores data (e.g. the metrics Write-Ahead Log)").Default("/tmp/coroot-node-agent").Envar("WAL_DIR").String()
+	WalMaxAge      = kingpin.Flag("wal-max-age", "The maximum age of the samples kept in the metrics Write-Ahead Log when remote write can't keep up, the older samples are dropped (0 means the Prometheus agent default of 4h)").Default("1h").Envar("WAL_MAX_AGE").Duration()
+
+	RemoteWriteConfig = kingpin.Flag("remote-write-config", "Path to a YAML file with additional `remote_write` destinations (Prometheus format)").Envar("REMOTE_WRITE_CONFIG").String()
+
+	MetricRelabelConfig = kingpin.Flag("metric-relabel-config", "Path to a YAML file with `metric_relabel_configs` (Prometheus format) applied to the exposed and sent metrics").Envar("METRIC_RELABEL_CONFIG").String()
+
+	FileExport                 = kingpin.Flag("file-export", "Write metrics, traces, logs and profiles to rotating files in <wal-dir>/export").Default("false").Envar("FILE_EXPORT").Bool()
+	FileExportMaxFileSize      = kingpin.Flag("file-export-max-file-size", "The size at which an export file is rotated").Default("10MB").Envar("FILE_EXPORT_MAX_FILE_SIZE").Bytes()
+	FileExportRotationInterval = kingpin.Flag("file-export-rotation-interval", "The maximum age of an export file before it is rotated").Default("1h").Envar("FILE_EXPORT_ROTATION_INTERVAL").Duration()
+	FileExportMaxFiles         = kingpin.Flag("file-export-max-files", "The number of files kept for each of traces and logs (metrics snapshots and profiles are limited to the same total size), so the export takes up to 4 * max-files * max-file-size of disk").Default("5").Envar("FILE_EXPORT_MAX_FILES").Int()
 )
 
 func GetString(fl *string) string {
@@ -55,7 +90,19 @@ func init() {
 
 	kingpin.HelpFlag.Short('h').Hidden()
 	kingpin.Parse()
+	if err := loadConfig(os.Args[1:]); err != nil {
+		kingpin.Fatalf("failed to load the config: %s", err)
+	}
 
+	setDefaultEndpoints()
+
+	if *MetricsEndpoint != nil {
+		*ListenAddress = "127.0.0.1:10300"
+	}
+}
+
+// setDefaultEndpoints derives the endpoints that aren't set explicitly from the collector endpoint.
+func setDefaultEndpoints() {
 	if *CollectorEndpoint != nil {
 		u := *CollectorEndpoint
 		if *MetricsEndpoint == nil {
@@ -71,8 +118,4 @@ func init() {
 			*ProfilesEndpoint = u.JoinPath("/v1/profiles")
 		}
 	}
-
-	if *MetricsEndpoint != nil {
-		*ListenAddress = "127.0.0.1:10300"
-	}
 }
@@ -0,0 +1,41 @@
+package governor
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	cpuUsage       = prometheus.NewDesc("node_agent_cpu_usage_cores", "CPU usage of the agent measured by the governor", nil, nil)
+	memoryHeap     = prometheus.NewDesc("node_agent_memory_heap_live_bytes", "Live heap memory of the agent measured by the governor", nil, nil)
+	sheddingActive = prometheus.NewDesc("node_agent_load_shedding_active", "Whether the work is shed because the agent exceeds its CPU or memory budget", []string{"step"}, nil)
+	sheddingTotal  = prometheus.NewDesc("node_agent_load_shedding_skipped_total", "Number of units of work skipped because the agent exceeds its CPU or memory budget", []string{"step"}, nil)
+)
+
+type Collector struct{}
+
+func NewCollector() *Collector {
+	return &Collector{}
+}
+
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- cpuUsage
+	ch <- memoryHeap
+	ch <- sheddingActive
+	ch <- sheddingTotal
+}
+
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	usageLock.Lock()
+	u := current
+	usageLock.Unlock()
+	ch <- prometheus.MustNewConstMetric(cpuUsage, prometheus.GaugeValue, u.cpuCores)
+	ch <- prometheus.MustNewConstMetric(memoryHeap, prometheus.GaugeValue, u.heapBytes)
+	for _, s := range steps {
+		active := 0.
+		if Shedding(s) {
+			active = 1
+		}
+		ch <- prometheus.MustNewConstMetric(sheddingActive, prometheus.GaugeValue, active, s.String())
+		ch <- prometheus.MustNewConstMetric(sheddingTotal, prometheus.CounterValue, float64(skipped[s].Load()), s.String())
+	}
+}
@@ -0,0 +1,226 @@
+package governor
+
+import (
+	"math"
+	"math/rand"
+	"runtime/debug"
+	"runtime/metrics"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/coroot/coroot-node-agent/flags"
+	"golang.org/x/sys/unix"
+	"k8s.io/klog/v2"
+)
+
+// The governor keeps the agent within the configured CPU and memory budgets.
+// While the agent exceeds a budget, the shedding level is raised by one step every interval,
+// and once the usage drops below recoveryThreshold of the budgets, it is lowered step by step.
+// Each level sheds the work of all the previous ones as well.
+// The memory usage is the live heap rather than RSS: the runtime keeps the freed pages for a while,
+// so RSS may stay above the budget long after the load is shed. The memory budget is also set
+// as the soft memory limit of the runtime so that the GC returns the memory sooner.
+
+type Step int32
+
+const (
+	StepNone          Step = 0
+	StepTraceSampling Step = 1
+	StepL7Parsing     Step = 2
+	StepLogParsing    Step = 3
+	StepProfiling     Step = 4
+)
+
+var steps = []Step{StepTraceSampling, StepL7Parsing, StepLogParsing, StepProfiling}
+
+func (s Step) String() string {
+	switch s {
+	case StepTraceSampling:
+		return "trace_sampling"
+	case StepL7Parsing:
+		return "l7_parsing"
+	case StepLogParsing:
+		return "log_parsing"
+	case StepProfiling:
+		return "profiling"
+	}
+	return "none"
+}
+
+const (
+	interval          = 10 * time.Second
+	recoveryThreshold = 0.8
+	traceSampleRate   = 10 // one of traceSampleRate traces is kept while sampling
+)
+
+type usage struct {
+	cpuCores  float64
+	heapBytes float64
+}
+
+var (
+	enabled atomic.Bool
+	level   atomic.Int32
+	skipped [StepProfiling + 1]atomic.Uint64
+
+	usageLock sync.Mutex
+	current   usage
+
+	l7Lock     sync.Mutex
+	l7Requests = map[string]int{}
+	noisy      = map[string]bool{}
+)
+
+func Start() {
+	cpuBudget, memoryBudget := *flags.CpuBudget, float64(*flags.MemoryBudget)
+	if cpuBudget <= 0 && memoryBudget <= 0 {
+		return
+	}
+	klog.Infof("governor: cpu budget=%.2f cores, memory budget=%.0f bytes", cpuBudget, memoryBudget)
+	if memoryBudget > 0 {
+		debug.SetMemoryLimit(int64(math.Min(memoryBudget, math.MaxInt64)))
+	}
+	enabled.Store(true)
+	go func() {
+		lastCpu, lastTime := cpuTime(), time.Now()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			cpu := cpuTime()
+			u := usage{cpuCores: (cpu - lastCpu).Seconds() / now.Sub(lastTime).Seconds(), heapBytes: liveHeap()}
+			lastCpu, lastTime = cpu, now
+			usageLock.Lock()
+			current = u
+			usageLock.Unlock()
+			adjust(u, cpuBudget, memoryBudget)
+			updateNoisy()
+		}
+	}()
+}
+
+func adjust(u usage, cpuBudget, memoryBudget float64) {
+	over := cpuBudget > 0 && u.cpuCores > cpuBudget || memoryBudget > 0 && u.heapBytes > memoryBudget
+	under := (cpuBudget <= 0 || u.cpuCores < cpuBudget*recoveryThreshold) && (memoryBudget <= 0 || u.heapBytes < memoryBudget*recoveryThreshold)
+	l := Step(level.Load())
+	switch {
+	case over && l < StepProfiling:
+		l++
+		klog.Warningf("governor: cpu=%.2f cores, heap=%.0f bytes exceed the budgets, shedding %s", u.cpuCores, u.heapBytes, l)
+	case under && l > StepNone:
+		klog.Infof("governor: cpu=%.2f cores, heap=%.0f bytes are within the budgets, resuming %s", u.cpuCores, u.heapBytes, l)
+		l--
+	default:
+		return
+	}
+	level.Store(int32(l))
+}
+
+// updateNoisy marks the containers that together produced at least half of the L7 requests during the last interval.
+func updateNoisy() {
+	l7Lock.Lock()
+	defer l7Lock.Unlock()
+	ids := make([]string, 0, len(l7Requests))
+	total := 0
+	for id, n := range l7Requests {
+		ids = append(ids, id)
+		total += n
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return l7Requests[ids[i]] > l7Requests[ids[j]]
+	})
+	noisy = map[string]bool{}
+	sum := 0
+	for _, id := range ids {
+		if sum*2 >= total {
+			break
+		}
+		noisy[id] = true
+		sum += l7Requests[id]
+	}
+	l7Requests = map[string]int{}
+}
+
+func Shedding(step Step) bool {
+	return Step(level.Load()) >= step
+}
+
+func skip(step Step) {
+	skipped[step].Add(1)
+}
+
+// SampleTrace reports whether the spans of a request should be created.
+func SampleTrace() bool {
+	if !Shedding(StepTraceSampling) {
+		return true
+	}
+	if rand.Intn(traceSampleRate) == 0 {
+		return true
+	}
+	skip(StepTraceSampling)
+	return false
+}
+
+// ParseL7 counts the L7 request of the container and reports whether its payload should be parsed.
+// Without budgets, the requests aren't counted.
+func ParseL7(containerId string) bool {
+	if !enabled.Load() {
+		return true
+	}
+	l7Lock.Lock()
+	l7Requests[containerId]++
+	isNoisy := noisy[containerId]
+	l7Lock.Unlock()
+	if isNoisy && Shedding(StepL7Parsing) {
+		skip(StepL7Parsing)
+		return false
+	}
+	return true
+}
+
+// RemoveContainer drops the L7 request stats of a removed container.
+func RemoveContainer(containerId string) {
+	l7Lock.Lock()
+	defer l7Lock.Unlock()
+	delete(l7Requests, containerId)
+	delete(noisy, containerId)
+}
+
+// ParseLogs reports whether a log entry should be passed to the log parser.
+func ParseLogs() bool {
+	if Shedding(StepLogParsing) {
+		skip(StepLogParsing)
+		return false
+	}
+	return true
+}
+
+// Profile reports whether the profiles should be collected.
+func Profile() bool {
+	if Shedding(StepProfiling) {
+		skip(StepProfiling)
+		return false
+	}
+	return true
+}
+
+func cpuTime() time.Duration {
+	var ru unix.Rusage
+	if err := unix.Getrusage(unix.RUSAGE_SELF, &ru); err != nil {
+		return 0
+	}
+	return time.Duration(ru.Utime.Nano() + ru.Stime.Nano())
+}
+
+const liveHeapMetric = "/gc/heap/live:bytes"
+
+// liveHeap returns the heap memory occupied by the objects marked live by the last GC cycle.
+func liveHeap() float64 {
+	sample := []metrics.Sample{{Name: liveHeapMetric}}
+	metrics.Read(sample)
+	if sample[0].Value.Kind() != metrics.KindUint64 {
+		return 0
+	}
+	return float64(sample[0].Value.Uint64())
+}
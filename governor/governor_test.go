@@ -0,0 +1,86 @@
+package governor
+
+import (
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdjust(t *testing.T) {
+	defer level.Store(int32(StepNone))
+
+	adjust(usage{cpuCores: 0.5, heapBytes: 100}, 1, 1000)
+	assert.Equal(t, StepNone, Step(level.Load()))
+
+	adjust(usage{cpuCores: 1.5, heapBytes: 100}, 1, 1000)
+	assert.Equal(t, StepTraceSampling, Step(level.Load()))
+	adjust(usage{cpuCores: 0.5, heapBytes: 2000}, 1, 1000)
+	assert.Equal(t, StepL7Parsing, Step(level.Load()))
+	adjust(usage{cpuCores: 1.5, heapBytes: 2000}, 1, 1000)
+	adjust(usage{cpuCores: 1.5, heapBytes: 2000}, 1, 1000)
+	adjust(usage{cpuCores: 1.5, heapBytes: 2000}, 1, 1000)
+	assert.Equal(t, StepProfiling, Step(level.Load()))
+	assert.False(t, Profile())
+	assert.False(t, ParseLogs())
+
+	adjust(usage{cpuCores: 0.9, heapBytes: 100}, 1, 1000) // within the budget, but above the recovery threshold
+	assert.Equal(t, StepProfiling, Step(level.Load()))
+	adjust(usage{cpuCores: 0.5, heapBytes: 100}, 1, 1000)
+	assert.Equal(t, StepLogParsing, Step(level.Load()))
+	assert.True(t, Profile())
+	assert.False(t, ParseLogs())
+}
+
+func TestAdjustRecoversWhenHeapDrops(t *testing.T) {
+	defer level.Store(int32(StepNone))
+
+	runtime.GC()
+	budget := liveHeap() + 64<<20
+
+	ballast := make([]byte, 128<<20)
+	for i := range ballast {
+		ballast[i] = 1
+	}
+	runtime.GC()
+	adjust(usage{heapBytes: liveHeap()}, 0, budget)
+	assert.Equal(t, StepTraceSampling, Step(level.Load()))
+	runtime.KeepAlive(ballast)
+
+	ballast = nil
+	runtime.GC()
+	adjust(usage{heapBytes: liveHeap()}, 0, budget)
+	assert.Equal(t, StepNone, Step(level.Load()))
+}
+
+func TestParseL7(t *testing.T) {
+	defer level.Store(int32(StepNone))
+
+	ParseL7("noisy")
+	assert.Empty(t, l7Requests, "the requests aren't counted without budgets")
+
+	enabled.Store(true)
+	defer enabled.Store(false)
+
+	for i := 0; i < 80; i++ {
+		ParseL7("noisy")
+	}
+	for i := 0; i < 15; i++ {
+		ParseL7("busy")
+	}
+	for i := 0; i < 5; i++ {
+		ParseL7("quiet")
+	}
+	updateNoisy()
+	assert.Equal(t, map[string]bool{"noisy": true}, noisy)
+
+	assert.True(t, ParseL7("noisy"))
+	level.Store(int32(StepL7Parsing))
+	assert.False(t, ParseL7("noisy"))
+	assert.True(t, ParseL7("busy"))
+
+	RemoveContainer("noisy")
+	assert.Empty(t, noisy)
+	assert.NotContains(t, l7Requests, "noisy")
+	assert.True(t, ParseL7("noisy"))
+}
@@ -0,0 +1,180 @@
+package health
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+const (
+	HeartbeatInterval = 5 * time.Second
+	HeartbeatTimeout  = time.Minute
+)
+
+type Status string
+
+const (
+	StatusOk      Status = "ok"
+	StatusFailed  Status = "failed"
+	StatusPending Status = "pending"
+)
+
+type Check struct {
+	Status      Status     `json:"status"`
+	Required    bool       `json:"required,omitempty"`
+	Error       string     `json:"error,omitempty"`
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+	LastError   *time.Time `json:"last_error,omitempty"`
+}
+
+type Response struct {
+	Status Status            `json:"status"`
+	Checks map[string]*Check `json:"checks"`
+}
+
+var (
+	lock       sync.Mutex
+	components = map[string]*Check{}
+	heartbeats = map[string]time.Time{}
+	probes     []func() map[string]Check
+)
+
+// Require marks the components that must report success before the agent is considered ready.
+func Require(names ...string) {
+	lock.Lock()
+	defer lock.Unlock()
+	for _, name := range names {
+		getComponent(name).Required = true
+	}
+}
+
+// Optional marks the components whose failure doesn't make the agent unready, e.g., because a fallback is used.
+func Optional(names ...string) {
+	lock.Lock()
+	defer lock.Unlock()
+	for _, name := range names {
+		getComponent(name).Required = false
+	}
+}
+
+// Report records the result of a component's setup or of an exporter's attempt to send data.
+func Report(name string, err error) {
+	now := time.Now()
+	lock.Lock()
+	defer lock.Unlock()
+	c := getComponent(name)
+	if err != nil {
+		c.Status = StatusFailed
+		c.Error = err.Error()
+		c.LastError = &now
+		return
+	}
+	c.Status = StatusOk
+	c.Error = ""
+	c.LastSuccess = &now
+}
+
+// Heartbeat is called periodically by the loops checked by the liveness probe.
+func Heartbeat(name string) {
+	now := time.Now()
+	lock.Lock()
+	heartbeats[name] = now
+	lock.Unlock()
+}
+
+// RemoveHeartbeat stops checking the loop, e.g., once it has finished.
+func RemoveHeartbeat(name string) {
+	lock.Lock()
+	delete(heartbeats, name)
+	lock.Unlock()
+}
+
+// RegisterProbe adds checks that are evaluated on each request to the readiness endpoint.
+func RegisterProbe(f func() map[string]Check) {
+	lock.Lock()
+	defer lock.Unlock()
+	probes = append(probes, f)
+}
+
+func Ready() Response {
+	lock.Lock()
+	checks := map[string]*Check{}
+	for name, c := range components {
+		cc := *c
+		checks[name] = &cc
+	}
+	ps := probes
+	lock.Unlock()
+
+	for _, f := range ps {
+		for name, c := range f() {
+			c := c
+			checks[name] = &c
+		}
+	}
+	res := Response{Status: StatusOk, Checks: checks}
+	for _, c := range checks {
+		if c.Required && c.Status != StatusOk {
+			res.Status = StatusFailed
+		}
+	}
+	return res
+}
+
+func Live(now time.Time) Response {
+	lock.Lock()
+	defer lock.Unlock()
+	res := Response{Status: StatusOk, Checks: map[string]*Check{}}
+	for name, t := range heartbeats {
+		t := t
+		c := &Check{Status: StatusOk, Required: true, LastSuccess: &t}
+		if now.Sub(t) > HeartbeatTimeout {
+			c.Status = StatusFailed
+			c.Error = "no heartbeat since " + t.Format(time.RFC3339)
+			res.Status = StatusFailed
+		}
+		res.Checks[name] = c
+	}
+	return res
+}
+
+func ReadyHandler(w http.ResponseWriter, _ *http.Request) {
+	writeResponse(w, Ready())
+}
+
+func LiveHandler(w http.ResponseWriter, _ *http.Request) {
+	writeResponse(w, Live(time.Now()))
+}
+
+func writeResponse(w http.ResponseWriter, res Response) {
+	if res.Status != StatusOk {
+		var failed []string
+		for name, c := range res.Checks {
+			if c.Required && c.Status != StatusOk {
+				failed = append(failed, name)
+			}
+		}
+		sort.Strings(failed)
+		klog.Warningln("health check failed:", failed)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if res.Status != StatusOk {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		klog.Errorln(err)
+	}
+}
+
+func getComponent(name string) *Check {
+	c := components[name]
+	if c == nil {
+		c = &Check{Status: StatusPending}
+		components[name] = c
+	}
+	return c
+}
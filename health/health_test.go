@@ -0,0 +1,43 @@
+package health
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReady(t *testing.T) {
+	Require("ebpf", "cgroup")
+	assert.Equal(t, StatusFailed, Ready().Status)
+
+	Report("ebpf", nil)
+	Report("cgroup", nil)
+	Report("dockerd", errors.New("no such file"))
+	res := Ready()
+	assert.Equal(t, StatusOk, res.Status)
+	assert.Equal(t, StatusFailed, res.Checks["dockerd"].Status)
+	assert.Equal(t, "no such file", res.Checks["dockerd"].Error)
+
+	Report("cgroup", errors.New("failed"))
+	assert.Equal(t, StatusFailed, Ready().Status)
+
+	Optional("cgroup")
+	assert.Equal(t, StatusOk, Ready().Status)
+
+	RegisterProbe(func() map[string]Check {
+		return map[string]Check{"remote_write:http://example.com": {Status: StatusPending}}
+	})
+	assert.Equal(t, StatusPending, Ready().Checks["remote_write:http://example.com"].Status)
+}
+
+func TestLive(t *testing.T) {
+	Heartbeat("events_loop")
+	now := time.Now()
+	assert.Equal(t, StatusOk, Live(now).Status)
+	assert.Equal(t, StatusFailed, Live(now.Add(2*HeartbeatTimeout)).Status)
+
+	RemoveHeartbeat("events_loop")
+	assert.Equal(t, StatusOk, Live(now.Add(2*HeartbeatTimeout)).Status)
+}
@@ -2,6 +2,7 @@ package logs
 
 import (
 	"context"
+	"sync"
 	"time"
 
 	otel "github.com/agoda-com/opentelemetry-logs-go"
@@ -10,59 +11,128 @@ import (
 	otelLogs "github.com/agoda-com/opentelemetry-logs-go/logs"
 	sdk "github.com/agoda-com/opentelemetry-logs-go/sdk/logs"
 	"github.com/coroot/coroot-node-agent/common"
+	"github.com/coroot/coroot-node-agent/fileexport"
 	"github.com/coroot/coroot-node-agent/flags"
+	"github.com/coroot/coroot-node-agent/health"
 	"github.com/coroot/logparser"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/sdk/resource"
 	semconv "go.opentelemetry.io/otel/semconv/v1.18.0"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
 	"k8s.io/klog/v2"
 )
 
-var otelLogger otelLogs.Logger
+var (
+	otelLogger     otelLogs.Logger
+	loggerProvider *sdk.LoggerProvider
+	otelLock       sync.RWMutex
+)
 
 func Init(machineId, hostname, version string) {
-	endpointUrl := *flags.LogsEndpoint
-	if endpointUrl == nil {
+	setupOtel(machineId, hostname, version)
+	flags.OnReload(func() {
+		setupOtel(machineId, hostname, version)
+	}, "logs-endpoint", "collector-endpoint", "api-key")
+}
+
+// setupOtel (re)creates the logs exporters, the previous provider is flushed and shut down.
+func setupOtel(machineId, hostname, version string) {
+	var opts []sdk.LoggerProviderOption
+	if endpointUrl := flags.Get(flags.LogsEndpoint); endpointUrl != nil {
+		klog.Infoln("OpenTelemetry logs collector endpoint:", endpointUrl.String())
+		path := endpointUrl.Path
+		if path == "" {
+			path = "/"
+		}
+		clientOpts := []otlplogshttp.Option{
+			otlplogshttp.WithEndpoint(endpointUrl.Host),
+			otlplogshttp.WithURLPath(path),
+			otlplogshttp.WithHeaders(common.AuthHeaders()),
+		}
+		if endpointUrl.Scheme != "https" {
+			clientOpts = append(clientOpts, otlplogshttp.WithInsecure())
+		}
+		opts = append(opts, newBatcher("logs_exporter", otlplogshttp.NewClient(clientOpts...)))
+	} else {
 		klog.Infoln("no OpenTelemetry logs collector endpoint configured")
-		return
 	}
-	klog.Infoln("OpenTelemetry logs collector endpoint:", endpointUrl.String())
-	path := endpointUrl.Path
-	if path == "" {
-		path = "/"
+	if w := fileexport.GetWriter(fileexport.Logs); w != nil {
+		opts = append(opts, newBatcher("logs_file_export", fileexport.NewOtlpClient(w)))
+	}
+	var provider *sdk.LoggerProvider
+	var logger otelLogs.Logger
+	if len(opts) > 0 {
+		provider = sdk.NewLoggerProvider(
+			append(opts,
+				sdk.WithResource(
+					resource.NewWithAttributes(
+						semconv.SchemaURL,
+						semconv.ServiceName("coroot-node-agent"),
+						semconv.HostName(hostname),
+						semconv.HostID(machineId),
+					),
+				),
+			)...,
+		)
+		otel.SetLoggerProvider(provider)
+		logger = provider.Logger("coroot-node-agent", otelLogs.WithInstrumentationVersion(version))
 	}
 
-	opts := []otlplogshttp.Option{
-		otlplogshttp.WithEndpoint(endpointUrl.Host),
-		otlplogshttp.WithURLPath(path),
-		otlplogshttp.WithHeaders(common.AuthHeaders()),
+	otelLock.Lock()
+	prev := loggerProvider
+	loggerProvider, otelLogger = provider, logger
+	otelLock.Unlock()
+	if prev != nil {
+		if err := prev.Shutdown(context.Background()); err != nil {
+			klog.Warningln(err)
+		}
 	}
-	if endpointUrl.Scheme != "https" {
-		opts = append(opts, otlplogshttp.WithInsecure())
+}
+
+// Shutdown flushes the buffered log records and stops the exporters.
+func Shutdown(ctx context.Context) {
+	otelLock.Lock()
+	prev := loggerProvider
+	loggerProvider, otelLogger = nil, nil
+	otelLock.Unlock()
+	if prev != nil {
+		if err := prev.Shutdown(ctx); err != nil {
+			klog.Warningln(err)
+		}
 	}
-	client := otlplogshttp.NewClient(opts...)
-	exporter, _ := otlplogs.NewExporter(context.Background(), otlplogs.WithClient(client))
+}
 
-	loggerProvider := sdk.NewLoggerProvider(
-		sdk.WithBatcher(exporter),
-		sdk.WithResource(
-			resource.NewWithAttributes(
-				semconv.SchemaURL,
-				semconv.ServiceName("coroot-node-agent"),
-				semconv.HostName(hostname),
-				semconv.HostID(machineId),
-			),
-		),
-	)
-	otel.SetLoggerProvider(loggerProvider)
-	otelLogger = loggerProvider.Logger("coroot-node-agent", otelLogs.WithInstrumentationVersion(version))
+func getOtelLogger() otelLogs.Logger {
+	otelLock.RLock()
+	defer otelLock.RUnlock()
+	return otelLogger
 }
 
+func newBatcher(name string, client otlplogs.Client) sdk.LoggerProviderOption {
+	exporter, _ := otlplogs.NewExporter(context.Background(), otlplogs.WithClient(&healthReportingClient{Client: client, name: name}))
+	return sdk.WithBatcher(exporter)
+}
+
+// healthReportingClient reports the result of each export to the health endpoint.
+type healthReportingClient struct {
+	otlplogs.Client
+	name string
+}
+
+func (c *healthReportingClient) UploadLogs(ctx context.Context, protoLogs []*logspb.ResourceLogs) error {
+	err := c.Client.UploadLogs(ctx, protoLogs)
+	health.Report(c.name, err)
+	return err
+}
+
+// OtelLogEmitter returns a callback sending the messages to the current logs exporter (if any),
+// so the exporter can be enabled or changed on config reload.
 func OtelLogEmitter(containerId string) logparser.OnMsgCallbackF {
-	if otelLogger == nil {
-		return nil
-	}
 	return func(ts time.Time, level logparser.Level, patternHash string, msg string) {
+		otelLogger := getOtelLogger()
+		if otelLogger == nil {
+			return
+		}
 		severityText := level.String()
 		severityNumber := otelLogs.UNSPECIFIED
 		switch level {
@@ -2,15 +2,22 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"net/http"
-	_ "net/http/pprof"
 	"os"
+	"os/signal"
 	"runtime"
 	"strings"
+	"syscall"
+	"time"
 
+	"github.com/coroot/coroot-node-agent/capabilities"
 	"github.com/coroot/coroot-node-agent/common"
 	"github.com/coroot/coroot-node-agent/containers"
+	"github.com/coroot/coroot-node-agent/fileexport"
 	"github.com/coroot/coroot-node-agent/flags"
+	"github.com/coroot/coroot-node-agent/governor"
+	"github.com/coroot/coroot-node-agent/health"
 	"github.com/coroot/coroot-node-agent/logs"
 	"github.com/coroot/coroot-node-agent/node"
 	"github.com/coroot/coroot-node-agent/proc"
@@ -29,9 +36,19 @@ var (
 	version = "unknown"
 )
 
-const minSupportedKernelVersion = "4.16"
+const (
+	minSupportedKernelVersion = "4.16"
+	shutdownTimeout           = 30 * time.Second
+	// the time given to flushing traces, logs and profiles, the rest is left for the remote write flush and closing the files
+	exportersShutdownTimeout = shutdownTimeout - prom.RemoteFlushDeadline - 5*time.Second
+)
 
 func uname() (string, string, error) {
+	if err := capabilities.Check(capabilities.FeatureUtsNs); err != nil {
+		klog.Warningln("can't switch to the host UTS namespace, using the hostname of the agent's one:", err)
+		return readUname()
+	}
+
 	runtime.LockOSThread()
 	defer runtime.UnlockOSThread()
 
@@ -55,6 +72,10 @@ func uname() (string, string, error) {
 	if err != nil {
 		return "", "", err
 	}
+	return readUname()
+}
+
+func readUname() (string, string, error) {
 	var utsname unix.Utsname
 	if err := unix.Uname(&utsname); err != nil {
 		return "", "", err
@@ -106,10 +127,26 @@ func whitelistNodeExternalNetworks() {
 
 func main() {
 	klog.LogToStderr(false)
-	klog.SetOutput(&RateLimitedLogOutput{limiter: rate.NewLimiter(rate.Limit(*flags.LogPerSecond), *flags.LogBurst)})
+	logLimiter := rate.NewLimiter(rate.Limit(flags.Get(flags.LogPerSecond)), flags.Get(flags.LogBurst))
+	klog.SetOutput(&RateLimitedLogOutput{limiter: logLimiter})
+	flags.OnReload(func() {
+		logLimiter.SetLimit(rate.Limit(flags.Get(flags.LogPerSecond)))
+		logLimiter.SetBurst(flags.Get(flags.LogBurst))
+	}, "log-per-second", "log-burst")
 
 	klog.Infoln("agent version:", version)
 
+	if err := capabilities.Init(); err != nil {
+		klog.Warningln("failed to read the capabilities of the agent:", err)
+	}
+	for _, f := range capabilities.Features() {
+		err := capabilities.Check(f)
+		health.Report("feature:"+string(f), err)
+		if err != nil {
+			klog.Warningf("%s is disabled: %s", f, err)
+		}
+	}
+
 	hostname, kv, err := uname()
 	if err != nil {
 		klog.Exitln("failed to get uname:", err)
@@ -130,6 +167,19 @@ func main() {
 	machineId := machineID()
 	systemUuid := systemUUID()
 
+	if err := prom.LoadRelabelConfig(flags.Get(flags.MetricRelabelConfig)); err != nil {
+		klog.Exitln("failed to load metric relabel config:", err)
+	}
+	flags.OnReload(func() {
+		if err := prom.LoadRelabelConfig(flags.Get(flags.MetricRelabelConfig)); err != nil {
+			klog.Errorln("failed to reload metric relabel config:", err)
+		}
+	})
+
+	if err := fileexport.Init(); err != nil {
+		klog.Exitln(err)
+	}
+
 	tracing.Init(machineId, hostname, version)
 	logs.Init(machineId, hostname, version)
 
@@ -137,6 +187,8 @@ func main() {
 	registerer := prometheus.WrapRegistererWith(prometheus.Labels{"machine_id": machineId, "system_uuid": systemUuid}, registry)
 
 	registerer.MustRegister(info("node_agent_info", version))
+	registerer.MustRegister(governor.NewCollector())
+	governor.Start()
 
 	if err := registerer.Register(node.NewCollector(hostname, kv)); err != nil {
 		klog.Exitln(err)
@@ -148,18 +200,82 @@ func main() {
 	if err != nil {
 		klog.Exitln(err)
 	}
-	defer cr.Close()
 
 	profiling.Start()
-	defer profiling.Stop()
 
-	if err := prom.StartAgent(machineId); err != nil {
+	gatherer := prom.NewSnapshotGatherer(registry)
+	handlerOpts := promhttp.HandlerOpts{ErrorLog: logger{}, Registry: registerer, EnableOpenMetrics: true}
+	if err := prom.StartAgent(machineId, gatherer, handlerOpts); err != nil {
 		klog.Exitln(err)
 	}
+	relabeled := prom.NewRelabelingGatherer(gatherer)
+	fileexport.StartMetrics(relabeled, *flags.ScrapeInterval)
+
+	metricsHandler := promhttp.HandlerFor(relabeled, handlerOpts)
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metricsHandler)
+	mux.HandleFunc("/healthz", health.LiveHandler)
+	mux.HandleFunc("/readyz", health.ReadyHandler)
+	probes := http.NewServeMux()
+	probes.HandleFunc("/healthz", health.LiveHandler)
+	probes.HandleFunc("/readyz", health.ReadyHandler)
+	debug := http.NewServeMux()
+	debug.Handle("/metrics", metricsHandler)
+	debug.HandleFunc("/healthz", health.LiveHandler)
+	debug.HandleFunc("/readyz", health.ReadyHandler)
+	debug.HandleFunc("/debug/containers", cr.InventoryHandler)
+	debug.HandleFunc("/debug/events", cr.EventStreamHandler)
+	flags.WatchConfig()
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- serve(mux, debug, probes)
+	}()
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	select {
+	case err = <-serveErr:
+		klog.Errorln(err)
+	case s := <-signals:
+		klog.Infof("%s received, shutting down", s)
+	}
+	go func() {
+		s := <-signals
+		klog.Errorf("%s received, exiting without flushing the buffered data", s)
+		klog.Flush()
+		os.Exit(1)
+	}()
+	shutdown(cr)
+}
 
-	http.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{ErrorLog: logger{}, Registry: registerer}))
-	klog.Infoln("listening on:", *flags.ListenAddress)
-	klog.Errorln(http.ListenAndServe(*flags.ListenAddress, nil))
+// shutdown stops the data sources first and then flushes the exporters, so that no data is lost on a restart.
+// The whole sequence is bounded by shutdownTimeout, the remaining data is dropped if it isn't flushed in time.
+func shutdown(cr *containers.Registry) {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		cr.Close()
+		klog.Infoln("eBPF programs detached, pending events handled")
+
+		exportersCtx, cancelExporters := context.WithTimeout(ctx, exportersShutdownTimeout)
+		defer cancelExporters()
+		tracing.Shutdown(exportersCtx)
+		logs.Shutdown(exportersCtx)
+		profiling.Stop()
+		klog.Infoln("traces, logs and profiles flushed")
+
+		prom.StopAgent()
+		fileexport.Close()
+	}()
+	select {
+	case <-done:
+		klog.Infoln("shutdown complete")
+	case <-ctx.Done():
+		klog.Errorf("shutdown didn't complete within %s, exiting without flushing the remaining data", shutdownTimeout)
+	}
+	klog.Flush()
 }
 
 func info(name, version string) prometheus.Collector {
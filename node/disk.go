@@ -7,6 +7,7 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/coroot/coroot-node-agent/common"
 	"k8s.io/klog/v2"
 )
 
@@ -60,7 +61,12 @@ func (disks *Disks) GetParentBlockDevice(majorMinor string) *DevStat {
 	return nil
 }
 
+// GetDisks returns the disk stats, shared by all the collectors within a scrape. The result must not be modified.
 func GetDisks() (*Disks, error) {
+	return common.Snapshot("diskstats", readDisks)
+}
+
+func readDisks() (*Disks, error) {
 	data, err := os.ReadFile(path.Join(procRoot, "diskstats"))
 	if err != nil {
 		return nil, err
@@ -0,0 +1,64 @@
+package proc
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+)
+
+type NetDevStat struct {
+	Interface string
+	RxBytes   uint64
+	RxPackets uint64
+	RxErrors  uint64
+	RxDrops   uint64
+	TxBytes   uint64
+	TxPackets uint64
+	TxErrors  uint64
+	TxDrops   uint64
+}
+
+// GetNetDev returns the counters of the network interfaces (except loopback) of the network namespace of the process.
+func GetNetDev(pid uint32) ([]NetDevStat, error) {
+	f, err := os.Open(Path(pid, "net", "dev"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var res []NetDevStat
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// Inter-|   Receive                                                |  Transmit
+		//  face |bytes    packets errs drop fifo frame compressed multicast|bytes    packets errs drop fifo colls carrier compressed
+		//   eth0: 1234      12     0    0    0     0          0         0    5678      34    0    0    0     0       0          0
+		name, counters, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+		name = strings.TrimSpace(name)
+		if name == "lo" {
+			continue
+		}
+		fields := strings.Fields(counters)
+		if len(fields) < 12 {
+			continue
+		}
+		v := make([]uint64, 12)
+		for i := range v {
+			v[i], _ = strconv.ParseUint(fields[i], 10, 64)
+		}
+		res = append(res, NetDevStat{
+			Interface: name,
+			RxBytes:   v[0],
+			RxPackets: v[1],
+			RxErrors:  v[2],
+			RxDrops:   v[3],
+			TxBytes:   v[8],
+			TxPackets: v[9],
+			TxErrors:  v[10],
+			TxDrops:   v[11],
+		})
+	}
+	return res, scanner.Err()
+}
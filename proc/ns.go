@@ -1,8 +1,10 @@
 package proc
 
 import (
+	"fmt"
 	"runtime"
 
+	"github.com/coroot/coroot-node-agent/capabilities"
 	"github.com/vishvananda/netlink"
 	"github.com/vishvananda/netns"
 	"golang.org/x/sys/unix"
@@ -10,7 +12,7 @@ import (
 )
 
 func GetNetNs(pid uint32) (netns.NsHandle, error) {
-	return netns.GetFromPid(int(pid))
+	return netns.GetFromPath(Path(pid, "ns", "net"))
 }
 
 func GetSelfNetNs() (netns.NsHandle, error) {
@@ -25,6 +27,9 @@ func ExecuteInNetNs(newNs, curNs netns.NsHandle, f func() error) error {
 	if newNs.Equal(curNs) {
 		return f()
 	}
+	if err := capabilities.Check(capabilities.FeatureNetNsSwitch); err != nil {
+		return fmt.Errorf("can't switch the network namespace: %w", err)
+	}
 
 	runtime.LockOSThread()
 	defer runtime.UnlockOSThread()
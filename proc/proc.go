@@ -12,6 +12,11 @@ import (
 
 var root = "/proc"
 
+// SetRoot changes the directory procfs is read from, e.g., to a fixture in tests.
+func SetRoot(dir string) {
+	root = dir
+}
+
 func Path(pid uint32, subpath ...string) string {
 	return path.Join(append([]string{root, strconv.Itoa(int(pid))}, subpath...)...)
 }
@@ -20,6 +25,19 @@ func HostPath(p string) string {
 	return Path(1, "root", p)
 }
 
+// GetPidMax returns kernel.pid_max, the pids of the processes are less than this value.
+func GetPidMax() (uint32, error) {
+	data, err := os.ReadFile(path.Join(root, "sys", "kernel", "pid_max"))
+	if err != nil {
+		return 0, err
+	}
+	v, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 32)
+	if err != nil {
+		return 0, err
+	}
+	return uint32(v), nil
+}
+
 func GetCmdline(pid uint32) []byte {
 	cmdline, err := os.ReadFile(Path(pid, "cmdline"))
 	if err != nil {
@@ -19,6 +19,12 @@ func TestListPids(t *testing.T) {
 	assert.Equal(t, []uint32{123}, res)
 }
 
+func TestGetPidMax(t *testing.T) {
+	v, err := GetPidMax()
+	require.NoError(t, err)
+	assert.Equal(t, uint32(4194304), v)
+}
+
 func TestGetMountInfo(t *testing.T) {
 	res := GetMountInfo(123)
 	assert.Equal(t, map[string]MountInfo{
@@ -67,3 +73,12 @@ func TestGetSockets(t *testing.T) {
 		{Inode: "11154515", SAddr: ipp("127.0.0.1:8081"), DAddr: ipp("[::]:0"), Listen: true},
 	}, res)
 }
+
+func TestGetNetDev(t *testing.T) {
+	res, err := GetNetDev(123)
+	require.NoError(t, err)
+	assert.Equal(t, []NetDevStat{
+		{Interface: "eth0", RxBytes: 91855162, RxPackets: 63497, RxErrors: 1, RxDrops: 7, TxBytes: 12348872, TxPackets: 51203, TxDrops: 2},
+		{Interface: "eth1", RxBytes: 4096, RxPackets: 32, TxBytes: 1024, TxPackets: 16},
+	}, res)
+}
@@ -12,7 +12,10 @@ import (
 
 	"github.com/coroot/coroot-node-agent/common"
 	"github.com/coroot/coroot-node-agent/containers"
+	"github.com/coroot/coroot-node-agent/fileexport"
 	"github.com/coroot/coroot-node-agent/flags"
+	"github.com/coroot/coroot-node-agent/governor"
+	"github.com/coroot/coroot-node-agent/health"
 	"github.com/go-kit/log"
 	ebpfspy "github.com/grafana/pyroscope/ebpf"
 	"github.com/grafana/pyroscope/ebpf/metrics"
@@ -37,19 +40,41 @@ var (
 		Timeout: UploadTimeout,
 	}
 	endpointUrl  *url.URL
+	endpointLock sync.RWMutex
+	fileWriter   *fileexport.Writer
 	session      ebpfspy.Session
 	targetFinder = &TargetFinder{
 		processes: map[uint32]*processInfo{},
 	}
+	collectLock sync.Mutex
+	lastCollect time.Time
+	stopped     bool
 )
 
 func Init(hostId, hostName string) chan<- containers.ProcessInfo {
-	endpointUrl = *flags.ProfilesEndpoint
-	if endpointUrl == nil {
+	endpointUrl = flags.Get(flags.ProfilesEndpoint)
+	fileWriter = fileexport.GetWriter(fileexport.Profiles)
+	flags.OnReload(func() {
+		if session == nil {
+			klog.Warningln("profiling is not running, restart the agent to enable it")
+			return
+		}
+		endpointLock.Lock()
+		defer endpointLock.Unlock()
+		endpointUrl = flags.Get(flags.ProfilesEndpoint)
+		if endpointUrl != nil {
+			klog.Infoln("profiles endpoint:", endpointUrl.String())
+		} else {
+			klog.Infoln("no profiles endpoint configured")
+		}
+	}, "profiles-endpoint", "collector-endpoint")
+	if endpointUrl == nil && fileWriter == nil {
 		klog.Infoln("no profiles endpoint configured")
 		return nil
 	}
-	klog.Infoln("profiles endpoint:", endpointUrl.String())
+	if endpointUrl != nil {
+		klog.Infoln("profiles endpoint:", endpointUrl.String())
+	}
 
 	constLabels = labels.Labels{
 		{Name: "host.name", Value: hostName},
@@ -101,6 +126,7 @@ func Init(hostId, hostName string) chan<- containers.ProcessInfo {
 		session = nil
 		return nil
 	}
+	lastCollect = time.Now()
 	go collect()
 
 	processInfoCh := make(chan containers.ProcessInfo)
@@ -116,64 +142,106 @@ func Start() {
 	session.UpdateTargets(sd.TargetsOptions{})
 }
 
+// Stop collects and uploads the profiles accumulated since the last collection and stops the session.
 func Stop() {
-	if session != nil {
-		session.Stop()
+	if session == nil {
+		return
 	}
+	collectLock.Lock()
+	defer collectLock.Unlock()
+	if stopped {
+		return
+	}
+	collectAndUpload()
+	stopped = true
+	session.Stop()
 }
 
 func collect() {
 	ticker := time.NewTicker(CollectInterval)
 	defer ticker.Stop()
-	for t := range ticker.C {
-		session.UpdateTargets(sd.TargetsOptions{})
-		bs := pprof.NewProfileBuilders(SampleRate)
-		err := session.CollectProfiles(func(target *sd.Target, stack []string, value uint64, pid uint32, aggregation ebpfspy.SampleAggregation) {
-			pi := targetFinder.get(pid)
-			if pi == nil {
-				return
-			}
-			b := bs.BuilderForTarget(pi.hash, pi.labels)
-			if aggregation == ebpfspy.SampleAggregated {
-				b.CreateSample(stack, value)
-			} else {
-				b.CreateSampleOrAddValue(stack, value)
-			}
-		})
-		klog.Infof("collected %d profiles in %s", len(bs.Builders), time.Since(t).Truncate(time.Millisecond))
-		if err != nil {
-			klog.Errorln(err)
+	for range ticker.C {
+		collectLock.Lock()
+		if stopped {
+			collectLock.Unlock()
+			return
 		}
-		t = time.Now()
-		var uploaded int
-		for _, b := range bs.Builders {
-			err = upload(b)
-			if err != nil {
-				klog.Errorln(err)
-				break
-			}
-			uploaded++
+		if !governor.Profile() {
+			// the samples are kept in the kernel and collected once the load drops
+			collectLock.Unlock()
+			continue
 		}
-		klog.Infof("uploaded %d profiles in %s", uploaded, time.Since(t).Truncate(time.Millisecond))
+		collectAndUpload()
+		collectLock.Unlock()
 	}
 }
 
-func upload(b *pprof.ProfileBuilder) error {
-	u := *endpointUrl
-	q := u.Query()
-	for _, l := range append(b.Labels, constLabels...) {
-		q.Set(l.Name, l.Value)
+func collectAndUpload() {
+	t := time.Now()
+	duration := t.Sub(lastCollect)
+	lastCollect = t
+	session.UpdateTargets(sd.TargetsOptions{})
+	bs := pprof.NewProfileBuilders(SampleRate)
+	err := session.CollectProfiles(func(target *sd.Target, stack []string, value uint64, pid uint32, aggregation ebpfspy.SampleAggregation) {
+		pi := targetFinder.get(pid)
+		if pi == nil {
+			return
+		}
+		b := bs.BuilderForTarget(pi.hash, pi.labels)
+		if aggregation == ebpfspy.SampleAggregated {
+			b.CreateSample(stack, value)
+		} else {
+			b.CreateSampleOrAddValue(stack, value)
+		}
+	})
+	klog.Infof("collected %d profiles in %s", len(bs.Builders), time.Since(t).Truncate(time.Millisecond))
+	if err != nil {
+		klog.Errorln(err)
 	}
-	u.RawQuery = q.Encode()
+	t = time.Now()
+	var uploaded int
+	for _, b := range bs.Builders {
+		err = upload(b, duration)
+		if err != nil {
+			klog.Errorln(err)
+			break
+		}
+		uploaded++
+	}
+	if len(bs.Builders) > 0 {
+		health.Report("profiles_exporter", err)
+	}
+	klog.Infof("uploaded %d profiles in %s", uploaded, time.Since(t).Truncate(time.Millisecond))
+}
 
+func upload(b *pprof.ProfileBuilder, duration time.Duration) error {
 	b.Profile.SampleType[0].Type = "ebpf:cpu:nanoseconds"
-	b.Profile.DurationNanos = CollectInterval.Nanoseconds()
+	b.Profile.DurationNanos = duration.Nanoseconds()
 	body := bytes.NewBuffer(nil)
 	_, err := b.Write(body)
 	if err != nil {
 		return err
 	}
 
+	if fileWriter != nil {
+		if err = writeFile(b, body.Bytes()); err != nil {
+			return err
+		}
+	}
+	endpointLock.RLock()
+	endpoint := endpointUrl
+	endpointLock.RUnlock()
+	if endpoint == nil {
+		return nil
+	}
+
+	u := *endpoint
+	q := u.Query()
+	for _, l := range append(b.Labels, constLabels...) {
+		q.Set(l.Name, l.Value)
+	}
+	u.RawQuery = q.Encode()
+
 	req, err := http.NewRequest(http.MethodPost, u.String(), body)
 	if err != nil {
 		return err
@@ -196,6 +264,15 @@ func upload(b *pprof.ProfileBuilder) error {
 	return nil
 }
 
+func writeFile(b *pprof.ProfileBuilder, data []byte) error {
+	for _, l := range b.Labels {
+		if l.Name == "container.id" {
+			return fileWriter.WriteFile(url.PathEscape(l.Value), data)
+		}
+	}
+	return fileWriter.WriteFile("", data)
+}
+
 type TargetFinder struct {
 	processes map[uint32]*processInfo
 	lock      sync.Mutex
@@ -1,12 +1,18 @@
 package prom
 
 import (
+	"errors"
+	"net"
+	"net/http"
+	"sync"
 	"time"
 
 	"github.com/coroot/coroot-node-agent/common"
 	"github.com/coroot/coroot-node-agent/flags"
+	"github.com/coroot/coroot-node-agent/health"
 	"github.com/go-kit/log/level"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	promConfig "github.com/prometheus/common/config"
 	"github.com/prometheus/common/model"
 	"github.com/prometheus/prometheus/config"
@@ -20,45 +26,62 @@ import (
 )
 
 const (
-	RemoteFlushDeadline = time.Minute
+	// RemoteFlushDeadline must fit within the shutdown timeout of the agent along with flushing the other telemetry
+	RemoteFlushDeadline = 15 * time.Second
 	jobName             = "coroot-node-agent"
+	scrapePath          = "/metrics"
 	RemoteWriteTimeout  = 30 * time.Second
 )
 
-func StartAgent(machineId string) error {
-	logger := level.NewFilter(Logger{}, level.AllowInfo())
+var (
+	agentLock sync.Mutex
+	stopAgent func()
+)
 
-	if *flags.MetricsEndpoint == nil {
-		return nil
+// StartAgent starts scraping the metrics of the gatherer and sending them to the remote write destinations.
+// The metrics are scraped as they are, the scrape applies metric_relabel_configs itself.
+// If no destinations are configured, the agent is started once a config reload adds one.
+func StartAgent(machineId string, gatherer prometheus.Gatherer, opts promhttp.HandlerOpts) error {
+	handler := promhttp.HandlerFor(gatherer, opts)
+	cfg, err := agentConfig()
+	if err != nil {
+		return err
 	}
-	klog.Infoln("metrics remote write endpoint:", (*flags.MetricsEndpoint).String())
-	cfg := config.DefaultConfig
-	cfg.GlobalConfig.ScrapeInterval = model.Duration(*flags.ScrapeInterval)
-	cfg.GlobalConfig.ScrapeTimeout = model.Duration(*flags.ScrapeInterval)
-	cfg.RemoteWriteConfigs = append(cfg.RemoteWriteConfigs,
-		&config.RemoteWriteConfig{
-			URL:           &promConfig.URL{URL: *flags.MetricsEndpoint},
-			Headers:       common.AuthHeaders(),
-			RemoteTimeout: model.Duration(RemoteWriteTimeout),
-			QueueConfig:   config.DefaultQueueConfig,
-		},
-	)
-	cfg.ScrapeConfigs = append(cfg.ScrapeConfigs, &config.ScrapeConfig{
-		JobName:                 jobName,
-		HonorLabels:             true,
-		ScrapeClassicHistograms: true,
-		MetricsPath:             "/metrics",
-		Scheme:                  "http",
-		EnableCompression:       false,
-	})
+	if len(cfg.RemoteWriteConfigs) > 0 {
+		return startAgent(machineId, cfg, handler)
+	}
+	started := false
+	flags.OnReload(func() {
+		if started {
+			return
+		}
+		cfg, err := agentConfig()
+		if err != nil {
+			klog.Errorln("failed to reload the metrics config:", err)
+			return
+		}
+		if len(cfg.RemoteWriteConfigs) == 0 {
+			return
+		}
+		if err = startAgent(machineId, cfg, handler); err != nil {
+			klog.Errorln("failed to start the metrics agent:", err)
+			return
+		}
+		started = true
+	}, "metrics-endpoint", "remote-write-config")
+	return nil
+}
+
+func startAgent(machineId string, cfg *config.Config, handler http.Handler) error {
+	logger := level.NewFilter(Logger{}, level.AllowInfo())
 
-	opts := agent.DefaultOptions()
 	localStorage := &readyStorage{stats: tsdb.NewDBStats()}
 	scraper := &readyScrapeManager{}
-	remoteStorage := remote.NewStorage(logger, prometheus.DefaultRegisterer, localStorage.StartTime, *flags.WalDir, RemoteFlushDeadline, scraper)
+	remoteWriteMetrics := newRemoteWriteRegisterer(prometheus.DefaultRegisterer)
+	remoteStorage := remote.NewStorage(logger, remoteWriteMetrics, localStorage.StartTime, *flags.WalDir, RemoteFlushDeadline, scraper)
 	fanoutStorage := storage.NewFanout(logger, localStorage, remoteStorage)
 
-	if err := remoteStorage.ApplyConfig(&cfg); err != nil {
+	if err := remoteStorage.ApplyConfig(cfg); err != nil {
 		return err
 	}
 
@@ -66,17 +89,22 @@ func StartAgent(machineId string) error {
 	if err != nil {
 		return err
 	}
-	if err = scrapeManager.ApplyConfig(&cfg); err != nil {
+	if err = scrapeManager.ApplyConfig(cfg); err != nil {
 		return err
 	}
 	scraper.Set(scrapeManager)
-	db, err := agent.Open(logger, prometheus.DefaultRegisterer, remoteStorage, *flags.WalDir, opts)
+	db, err := agent.Open(logger, prometheus.DefaultRegisterer, remoteStorage, *flags.WalDir, walOptions(*flags.WalMaxAge))
 	if err != nil {
 		return err
 	}
 	localStorage.Set(db, 0)
 	db.SetWriteNotified(remoteStorage)
 
+	server, address, err := serveScrape(handler)
+	if err != nil {
+		return err
+	}
+
 	tch := make(chan map[string][]*targetgroup.Group, 1)
 	tch <- map[string][]*targetgroup.Group{
 		jobName: {
@@ -84,7 +112,7 @@ func StartAgent(machineId string) error {
 				Targets: []model.LabelSet{
 					{
 						model.InstanceLabel: model.LabelValue(machineId),
-						model.AddressLabel:  model.LabelValue(*flags.ListenAddress),
+						model.AddressLabel:  model.LabelValue(address),
 					},
 				},
 				Labels: model.LabelSet{model.JobLabel: jobName},
@@ -96,5 +124,102 @@ func StartAgent(machineId string) error {
 			klog.Errorln(err)
 		}
 	}()
+
+	flags.OnReload(func() {
+		cfg, err := agentConfig()
+		if err != nil {
+			klog.Errorln("failed to reload the metrics config:", err)
+			return
+		}
+		if err = remoteStorage.ApplyConfig(cfg); err != nil {
+			klog.Errorln("failed to apply the remote write config:", err)
+		}
+		if err = scrapeManager.ApplyConfig(cfg); err != nil {
+			klog.Errorln("failed to apply the scrape config:", err)
+		}
+	})
+
+	health.RegisterProbe(remoteWriteMetrics.checks)
+
+	agentLock.Lock()
+	stopAgent = func() {
+		scrapeManager.Stop()
+		_ = server.Close()
+		// closing the remote storage flushes the queues within RemoteFlushDeadline
+		if err := fanoutStorage.Close(); err != nil {
+			klog.Errorln(err)
+		}
+	}
+	agentLock.Unlock()
 	return nil
 }
+
+// StopAgent stops scraping and sends the pending samples to the remote write destinations.
+func StopAgent() {
+	agentLock.Lock()
+	stop := stopAgent
+	agentLock.Unlock()
+	if stop != nil {
+		stop()
+	}
+}
+
+func agentConfig() (*config.Config, error) {
+	remoteWriteConfigs, err := LoadRemoteWriteConfig(flags.Get(flags.RemoteWriteConfig))
+	if err != nil {
+		return nil, err
+	}
+	if endpointUrl := flags.Get(flags.MetricsEndpoint); endpointUrl != nil {
+		remoteWriteConfigs = append([]*config.RemoteWriteConfig{{
+			URL:                  &promConfig.URL{URL: endpointUrl},
+			Headers:              common.AuthHeaders(),
+			RemoteTimeout:        model.Duration(RemoteWriteTimeout),
+			QueueConfig:          config.DefaultQueueConfig,
+			SendExemplars:        true,
+			SendNativeHistograms: *flags.NativeHistograms,
+		}}, remoteWriteConfigs...)
+	}
+	for _, rw := range remoteWriteConfigs {
+		klog.Infoln("metrics remote write endpoint:", rw.URL.Redacted())
+	}
+	cfg := config.DefaultConfig
+	cfg.GlobalConfig.ScrapeInterval = model.Duration(*flags.ScrapeInterval)
+	cfg.GlobalConfig.ScrapeTimeout = model.Duration(*flags.ScrapeInterval)
+	cfg.RemoteWriteConfigs = remoteWriteConfigs
+	cfg.StorageConfig.ExemplarsConfig = &config.DefaultExemplarsConfig
+	scrapeConfig := &config.ScrapeConfig{
+		JobName:                 jobName,
+		HonorLabels:             true,
+		ScrapeClassicHistograms: true,
+		ScrapeProtocols:         config.DefaultScrapeProtocols, // exemplars are only exposed in OpenMetrics
+		MetricsPath:             scrapePath,
+		Scheme:                  "http",
+		EnableCompression:       false,
+		MetricRelabelConfigs:    RelabelConfigs(),
+	}
+	if *flags.NativeHistograms {
+		// native histograms are only exposed in the Prometheus protobuf format
+		scrapeConfig.ScrapeProtocols = config.DefaultProtoFirstScrapeProtocols
+		scrapeConfig.ScrapeClassicHistograms = false
+	}
+	cfg.ScrapeConfigs = append(cfg.ScrapeConfigs, scrapeConfig)
+	return &cfg, nil
+}
+
+// serveScrape serves the metrics to the internal scraper on an ephemeral loopback port.
+// Unlike the public /metrics handler, it isn't protected, so it must never be reachable from the outside.
+func serveScrape(handler http.Handler) (*http.Server, string, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, "", err
+	}
+	mux := http.NewServeMux()
+	mux.Handle(scrapePath, handler)
+	server := &http.Server{Handler: mux}
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			klog.Errorln(err)
+		}
+	}()
+	return server, listener.Addr().String(), nil
+}
@@ -0,0 +1,40 @@
+package prom
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServeScrape(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	c := prometheus.NewCounter(prometheus.CounterOpts{Name: "container_dotnet_exceptions_total"})
+	c.Add(1)
+	reg.MustRegister(c)
+
+	server, address, err := serveScrape(promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+	require.NoError(t, err)
+	defer server.Close()
+
+	host, _, err := net.SplitHostPort(address)
+	require.NoError(t, err)
+	assert.True(t, net.ParseIP(host).IsLoopback())
+
+	resp, err := http.Get("http://" + address + scrapePath)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err)
+	assert.Contains(t, string(body), "container_dotnet_exceptions_total 1")
+
+	resp, err = http.Get("http://" + address + "/debug/pprof/")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
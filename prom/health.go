@@ -0,0 +1,125 @@
+package prom
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coroot/coroot-node-agent/health"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"k8s.io/klog/v2"
+)
+
+const (
+	highestSentTimestampMetric = "prometheus_remote_storage_queue_highest_sent_timestamp_seconds"
+	remoteWriteStaleAfter      = 5 * time.Minute
+)
+
+// remoteWriteRegisterer keeps the highest sent timestamp gauges registered by the remote write queues,
+// so that the health probes read them without gathering the whole registry.
+type remoteWriteRegisterer struct {
+	prometheus.Registerer
+
+	lock        sync.Mutex
+	highestSent map[prometheus.Collector]struct{}
+}
+
+func newRemoteWriteRegisterer(r prometheus.Registerer) *remoteWriteRegisterer {
+	return &remoteWriteRegisterer{Registerer: r, highestSent: map[prometheus.Collector]struct{}{}}
+}
+
+func (r *remoteWriteRegisterer) Register(c prometheus.Collector) error {
+	if err := r.Registerer.Register(c); err != nil {
+		return err
+	}
+	if isHighestSentTimestamp(c) {
+		r.lock.Lock()
+		r.highestSent[c] = struct{}{}
+		r.lock.Unlock()
+	}
+	return nil
+}
+
+func (r *remoteWriteRegisterer) MustRegister(cs ...prometheus.Collector) {
+	for _, c := range cs {
+		if err := r.Register(c); err != nil {
+			panic(err)
+		}
+	}
+}
+
+func (r *remoteWriteRegisterer) Unregister(c prometheus.Collector) bool {
+	r.lock.Lock()
+	delete(r.highestSent, c)
+	r.lock.Unlock()
+	return r.Registerer.Unregister(c)
+}
+
+func isHighestSentTimestamp(c prometheus.Collector) bool {
+	ch := make(chan *prometheus.Desc, 1)
+	go func() {
+		c.Describe(ch)
+		close(ch)
+	}()
+	res := false
+	for d := range ch {
+		if strings.Contains(d.String(), `fqName: "`+highestSentTimestampMetric+`"`) {
+			res = true
+		}
+	}
+	return res
+}
+
+// checks reports the timestamp of the latest sample successfully sent to each remote write destination.
+func (r *remoteWriteRegisterer) checks() map[string]health.Check {
+	r.lock.Lock()
+	collectors := make([]prometheus.Collector, 0, len(r.highestSent))
+	for c := range r.highestSent {
+		collectors = append(collectors, c)
+	}
+	r.lock.Unlock()
+
+	res := map[string]health.Check{}
+	for _, c := range collectors {
+		ch := make(chan prometheus.Metric, 1)
+		go func() {
+			c.Collect(ch)
+			close(ch)
+		}()
+		for metric := range ch {
+			m := &dto.Metric{}
+			if err := metric.Write(m); err != nil {
+				klog.Warningln(err)
+				continue
+			}
+			var destination string
+			for _, l := range m.GetLabel() {
+				if l.GetName() == "url" {
+					destination = l.GetValue()
+				}
+			}
+			if u, err := url.Parse(destination); err == nil {
+				destination = u.Redacted()
+			}
+			res["remote_write:"+destination] = remoteWriteCheck(m.GetGauge().GetValue())
+		}
+	}
+	return res
+}
+
+func remoteWriteCheck(ts float64) health.Check {
+	c := health.Check{Status: health.StatusPending}
+	if ts > 0 {
+		t := time.UnixMilli(int64(ts * 1000))
+		c.LastSuccess = &t
+		c.Status = health.StatusOk
+		if time.Since(t) > remoteWriteStaleAfter {
+			c.Status = health.StatusFailed
+			c.Error = fmt.Sprintf("no samples sent for %s", time.Since(t).Truncate(time.Second))
+		}
+	}
+	return c
+}
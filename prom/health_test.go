@@ -0,0 +1,39 @@
+package prom
+
+import (
+	"testing"
+	"time"
+
+	"github.com/coroot/coroot-node-agent/health"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRemoteWriteChecks(t *testing.T) {
+	highestSent := func(url string) prometheus.Gauge {
+		return prometheus.NewGauge(prometheus.GaugeOpts{
+			Name:        highestSentTimestampMetric,
+			ConstLabels: prometheus.Labels{"remote_name": url, "url": url},
+		})
+	}
+	r := newRemoteWriteRegisterer(prometheus.NewRegistry())
+	ok := highestSent("http://user:secret@ok:8080/v1/metrics")
+	stale := highestSent("http://stale:8080/v1/metrics")
+	pending := highestSent("http://pending:8080/v1/metrics")
+	other := prometheus.NewGauge(prometheus.GaugeOpts{Name: "prometheus_remote_storage_shards"})
+	r.MustRegister(ok, stale, pending, other)
+	assert.Len(t, r.highestSent, 3)
+
+	ok.Set(float64(time.Now().Unix()))
+	stale.Set(float64(time.Now().Add(-time.Hour).Unix()))
+
+	checks := r.checks()
+	require.Len(t, checks, 3)
+	assert.Equal(t, health.StatusOk, checks["remote_write:http://user:xxxxx@ok:8080/v1/metrics"].Status)
+	assert.Equal(t, health.StatusFailed, checks["remote_write:http://stale:8080/v1/metrics"].Status)
+	assert.Equal(t, health.StatusPending, checks["remote_write:http://pending:8080/v1/metrics"].Status)
+
+	assert.True(t, r.Unregister(pending))
+	assert.Len(t, r.checks(), 2)
+}
@@ -0,0 +1,349 @@
+package prom
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/relabel"
+	"google.golang.org/protobuf/proto"
+	"gopkg.in/yaml.v2"
+)
+
+var (
+	relabelConfigs     []*relabel.Config
+	relabelConfigsLock sync.RWMutex
+)
+
+type relabelConfigFile struct {
+	MetricRelabelConfigs []*relabel.Config `yaml:"metric_relabel_configs"`
+}
+
+// LoadRelabelConfig reads the metric_relabel_configs section of a YAML file.
+// The rules follow the Prometheus semantics and are applied to the /metrics handler and to the remote-write scrape.
+func LoadRelabelConfig(path string) error {
+	if path == "" {
+		SetRelabelConfigs(nil)
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var cfg relabelConfigFile
+	if err = yaml.UnmarshalStrict(data, &cfg); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	for i, c := range cfg.MetricRelabelConfigs {
+		if c == nil {
+			return fmt.Errorf("failed to parse %s: empty relabel config #%d", path, i)
+		}
+	}
+	SetRelabelConfigs(cfg.MetricRelabelConfigs)
+	return nil
+}
+
+func SetRelabelConfigs(cfgs []*relabel.Config) {
+	relabelConfigsLock.Lock()
+	defer relabelConfigsLock.Unlock()
+	relabelConfigs = cfgs
+}
+
+func RelabelConfigs() []*relabel.Config {
+	relabelConfigsLock.RLock()
+	defer relabelConfigsLock.RUnlock()
+	return relabelConfigs
+}
+
+// RelabelingGatherer applies the current relabel rules to the gathered metrics.
+type RelabelingGatherer struct {
+	g prometheus.Gatherer
+}
+
+func NewRelabelingGatherer(g prometheus.Gatherer) *RelabelingGatherer {
+	return &RelabelingGatherer{g: g}
+}
+
+func (rg *RelabelingGatherer) Gather() ([]*dto.MetricFamily, error) {
+	mfs, err := rg.g.Gather()
+	cfgs := RelabelConfigs()
+	if len(cfgs) == 0 {
+		return mfs, err
+	}
+	return relabelMetricFamilies(mfs, cfgs), err
+}
+
+// relabeledSeries is a metric after relabeling.
+// Classic histograms and summaries keep their type only if the rules changed all their series in the same way,
+// otherwise they are split into untyped samples, just like the remote-write scrape sees them.
+type relabeledSeries struct {
+	name       string
+	labels     labels.Labels
+	typ        dto.MetricType
+	help       *string
+	metric     *dto.Metric
+	components []relabeledComponent // the series of a classic histogram or summary
+}
+
+type relabeledComponent struct {
+	labels labels.Labels
+	value  float64
+}
+
+// relabelMetricFamilies applies the rules to the series the remote-write scrape would ingest:
+// `<name>_bucket{le}`, `<name>_sum` and `<name>_count` for classic histograms, `<name>{quantile}`, `<name>_sum` and `<name>_count` for summaries.
+// Renamed metrics are moved to the corresponding families; series that become duplicates are dropped.
+// A family can't mix types, so histograms and summaries renamed into a family of another type are split into untyped samples
+// (native histograms are dropped), and counters, gauges and untyped metrics sharing a name become untyped.
+func relabelMetricFamilies(mfs []*dto.MetricFamily, cfgs []*relabel.Config) []*dto.MetricFamily {
+	var series []*relabeledSeries
+	for _, mf := range mfs {
+		native := len(mf.Metric) > 0 && isNativeHistogram(mf.Metric[0].GetHistogram())
+		for _, m := range mf.Metric {
+			switch {
+			case (mf.GetType() == dto.MetricType_HISTOGRAM || mf.GetType() == dto.MetricType_GAUGE_HISTOGRAM) && !native,
+				mf.GetType() == dto.MetricType_SUMMARY:
+				series = append(series, relabelClassic(mf, m, cfgs)...)
+			default:
+				if s := relabelScalar(mf, m, cfgs); s != nil {
+					series = append(series, s)
+				}
+			}
+		}
+	}
+
+	for {
+		types := map[string]map[dto.MetricType]bool{}
+		for _, s := range series {
+			if types[s.name] == nil {
+				types[s.name] = map[dto.MetricType]bool{}
+			}
+			types[s.name][s.typ] = true
+		}
+		var res []*relabeledSeries
+		changed := false
+		for _, s := range series {
+			if len(types[s.name]) > 1 {
+				switch {
+				case s.components != nil:
+					res = append(res, s.split()...)
+					changed = true
+					continue
+				case s.typ != dto.MetricType_COUNTER && s.typ != dto.MetricType_GAUGE && s.typ != dto.MetricType_UNTYPED:
+					changed = true // native histograms can't be represented as samples
+					continue
+				}
+			}
+			res = append(res, s)
+		}
+		series = res
+		if !changed {
+			break
+		}
+	}
+
+	families := map[string]*dto.MetricFamily{}
+	seen := map[uint64]struct{}{}
+	var res []*dto.MetricFamily
+	for _, s := range series {
+		h := s.labels.Hash()
+		if _, ok := seen[h]; ok {
+			continue
+		}
+		seen[h] = struct{}{}
+		f := families[s.name]
+		if f == nil {
+			f = &dto.MetricFamily{Name: proto.String(s.name), Help: s.help, Type: s.typ.Enum()}
+			families[s.name] = f
+			res = append(res, f)
+		} else if f.GetType() != s.typ {
+			f.Type = dto.MetricType_UNTYPED.Enum()
+		}
+		f.Metric = append(f.Metric, s.metric)
+	}
+	for _, f := range res {
+		if f.GetType() != dto.MetricType_UNTYPED {
+			continue
+		}
+		for i, m := range f.Metric {
+			f.Metric[i] = asUntyped(m)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].GetName() < res[j].GetName()
+	})
+	return res
+}
+
+func relabelScalar(mf *dto.MetricFamily, m *dto.Metric, cfgs []*relabel.Config) *relabeledSeries {
+	ls, keep := relabel.Process(metricLabels(mf.GetName(), m, "", ""), cfgs...)
+	if !keep {
+		return nil
+	}
+	name := ls.Get(labels.MetricName)
+	if name == "" {
+		return nil
+	}
+	return &relabeledSeries{name: name, labels: ls, typ: mf.GetType(), help: mf.Help, metric: withLabels(m, ls)}
+}
+
+func relabelClassic(mf *dto.MetricFamily, m *dto.Metric, cfgs []*relabel.Config) []*relabeledSeries {
+	type component struct {
+		suffix, magicName, magicValue string
+		value                         float64
+	}
+	name := mf.GetName()
+	var components []component
+	if mf.GetType() == dto.MetricType_SUMMARY {
+		s := m.GetSummary()
+		for _, q := range s.GetQuantile() {
+			components = append(components, component{"", model.QuantileLabel, formatOpenMetricsFloat(q.GetQuantile()), q.GetValue()})
+		}
+		components = append(components, component{"_sum", "", "", s.GetSampleSum()}, component{"_count", "", "", float64(s.GetSampleCount())})
+	} else {
+		h := m.GetHistogram()
+		count := float64(h.GetSampleCount())
+		if h.SampleCountFloat != nil {
+			count = h.GetSampleCountFloat()
+		}
+		inf := false
+		for _, b := range h.GetBucket() {
+			v := float64(b.GetCumulativeCount())
+			if b.CumulativeCountFloat != nil {
+				v = b.GetCumulativeCountFloat()
+			}
+			components = append(components, component{"_bucket", model.BucketLabel, formatOpenMetricsFloat(b.GetUpperBound()), v})
+			inf = math.IsInf(b.GetUpperBound(), +1)
+		}
+		if !inf {
+			components = append(components, component{"_bucket", model.BucketLabel, "+Inf", count})
+		}
+		components = append(components, component{"_sum", "", "", h.GetSampleSum()}, component{"_count", "", "", count})
+	}
+
+	res := &relabeledSeries{typ: mf.GetType(), help: mf.Help, metric: m}
+	uniform := true
+	var base labels.Labels
+	var baseName string
+	for i := len(components) - 1; i >= 0; i-- { // starting from _count, which defines the name and labels of the whole metric
+		c := components[i]
+		ls, keep := relabel.Process(metricLabels(name+c.suffix, m, c.magicName, c.magicValue), cfgs...)
+		if !keep || ls.Get(labels.MetricName) == "" {
+			uniform = false
+			continue
+		}
+		res.components = append(res.components, relabeledComponent{labels: ls, value: c.value})
+		if !uniform {
+			continue
+		}
+		if c.suffix == "_count" {
+			n := ls.Get(labels.MetricName)
+			if !strings.HasSuffix(n, "_count") || len(n) == len("_count") {
+				uniform = false
+				continue
+			}
+			baseName = strings.TrimSuffix(n, "_count")
+			base = labels.NewBuilder(ls).Set(labels.MetricName, baseName).Labels()
+			continue
+		}
+		if ls.Get(labels.MetricName) != baseName+c.suffix || ls.Get(c.magicName) != c.magicValue ||
+			!labels.Equal(labels.NewBuilder(ls).Set(labels.MetricName, baseName).Del(c.magicName).Labels(), base) {
+			uniform = false
+		}
+	}
+	if !uniform {
+		return res.split()
+	}
+	res.name = baseName
+	res.labels = base
+	res.metric = withLabels(m, base)
+	return []*relabeledSeries{res}
+}
+
+// split turns a classic histogram or summary into untyped samples.
+func (s *relabeledSeries) split() []*relabeledSeries {
+	res := make([]*relabeledSeries, 0, len(s.components))
+	for i := len(s.components) - 1; i >= 0; i-- {
+		c := s.components[i]
+		m := withLabels(&dto.Metric{Untyped: &dto.Untyped{Value: proto.Float64(c.value)}, TimestampMs: s.metric.TimestampMs}, c.labels)
+		res = append(res, &relabeledSeries{name: c.labels.Get(labels.MetricName), labels: c.labels, typ: dto.MetricType_UNTYPED, metric: m})
+	}
+	return res
+}
+
+func metricLabels(name string, m *dto.Metric, magicName, magicValue string) labels.Labels {
+	b := labels.NewScratchBuilder(len(m.Label) + 2)
+	b.Add(labels.MetricName, name)
+	for _, lp := range m.Label {
+		b.Add(lp.GetName(), lp.GetValue())
+	}
+	if magicName != "" {
+		b.Add(magicName, magicValue)
+	}
+	b.Sort()
+	return b.Labels()
+}
+
+func withLabels(m *dto.Metric, ls labels.Labels) *dto.Metric {
+	res := &dto.Metric{
+		Gauge:       m.Gauge,
+		Counter:     m.Counter,
+		Summary:     m.Summary,
+		Untyped:     m.Untyped,
+		Histogram:   m.Histogram,
+		TimestampMs: m.TimestampMs,
+	}
+	ls.Range(func(l labels.Label) {
+		if l.Name == labels.MetricName {
+			return
+		}
+		res.Label = append(res.Label, &dto.LabelPair{Name: proto.String(l.Name), Value: proto.String(l.Value)})
+	})
+	return res
+}
+
+func asUntyped(m *dto.Metric) *dto.Metric {
+	if m.Untyped != nil {
+		return m
+	}
+	v := m.GetGauge().GetValue()
+	if m.Counter != nil {
+		v = m.GetCounter().GetValue()
+	}
+	return &dto.Metric{Label: m.Label, Untyped: &dto.Untyped{Value: proto.Float64(v)}, TimestampMs: m.TimestampMs}
+}
+
+// isNativeHistogram and formatOpenMetricsFloat match github.com/prometheus/prometheus/model/textparse,
+// so that the series are named and labeled exactly as the scrape sees them.
+func isNativeHistogram(h *dto.Histogram) bool {
+	return len(h.GetPositiveSpan()) > 0 || len(h.GetNegativeSpan()) > 0 || h.GetZeroThreshold() > 0 || h.GetZeroCount() > 0
+}
+
+func formatOpenMetricsFloat(f float64) string {
+	switch {
+	case f == 1:
+		return "1.0"
+	case f == 0:
+		return "0.0"
+	case f == -1:
+		return "-1.0"
+	case math.IsNaN(f):
+		return "NaN"
+	case math.IsInf(f, +1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	}
+	s := fmt.Sprint(f)
+	if strings.ContainsAny(s, "e.") {
+		return s
+	}
+	return s + ".0"
+}
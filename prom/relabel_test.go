@@ -0,0 +1,156 @@
+package prom
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testRelabelConfig = `
+metric_relabel_configs:
+- source_labels: [__name__]
+  regex: container_dotnet_.+
+  action: drop
+- regex: sample
+  action: labeldrop
+- source_labels: [__name__]
+  regex: container_net_tcp_(.+)
+  target_label: __name__
+  replacement: tcp_${1}
+- source_labels: [level]
+  regex: debug
+  action: drop
+`
+
+func TestRelabelingGatherer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "relabel.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(testRelabelConfig), 0644))
+	require.NoError(t, LoadRelabelConfig(path))
+	defer SetRelabelConfigs(nil)
+	require.Len(t, RelabelConfigs(), 4)
+
+	reg := prometheus.NewRegistry()
+	logs := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "container_log_messages_total"}, []string{"level", "pattern_hash", "sample"})
+	logs.WithLabelValues("info", "h1", "started").Add(1)
+	logs.WithLabelValues("debug", "h2", "checking").Add(2)
+	dotnet := prometheus.NewGauge(prometheus.GaugeOpts{Name: "container_dotnet_info"})
+	tcp := prometheus.NewCounter(prometheus.CounterOpts{Name: "container_net_tcp_successful_connects_total"})
+	reg.MustRegister(logs, dotnet, tcp)
+
+	mfs, err := NewRelabelingGatherer(reg).Gather()
+	require.NoError(t, err)
+	require.Len(t, mfs, 2)
+
+	assert.Equal(t, "container_log_messages_total", mfs[0].GetName())
+	require.Len(t, mfs[0].Metric, 1)
+	assert.Equal(t, map[string]string{"level": "info", "pattern_hash": "h1"}, labelsMap(mfs[0].Metric[0]))
+
+	assert.Equal(t, "tcp_successful_connects_total", mfs[1].GetName())
+	assert.Equal(t, dto.MetricType_COUNTER, mfs[1].GetType())
+	require.Len(t, mfs[1].Metric, 1)
+	assert.Empty(t, mfs[1].Metric[0].Label)
+}
+
+func TestLoadRelabelConfigInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "relabel.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("metric_relabel_configs:\n- action: unknown\n"), 0644))
+	assert.Error(t, LoadRelabelConfig(path))
+	assert.Nil(t, RelabelConfigs())
+}
+
+func labelsMap(m *dto.Metric) map[string]string {
+	res := map[string]string{}
+	for _, lp := range m.Label {
+		res[lp.GetName()] = lp.GetValue()
+	}
+	return res
+}
+
+const testClassicRelabelConfig = `
+metric_relabel_configs:
+- source_labels: [__name__, le]
+  regex: dropped_bucket_seconds_bucket;0\.1
+  action: drop
+- source_labels: [__name__]
+  regex: old_(.+)
+  target_label: __name__
+  replacement: new_${1}
+- source_labels: [quantile]
+  regex: '0\.5'
+  action: drop
+- source_labels: [__name__]
+  regex: (gauge|conflicting_seconds)(.*)
+  target_label: __name__
+  replacement: counter_total${2}
+`
+
+func TestRelabelingGathererClassicTypes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "relabel.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(testClassicRelabelConfig), 0644))
+	require.NoError(t, LoadRelabelConfig(path))
+	defer SetRelabelConfigs(nil)
+
+	reg := prometheus.NewRegistry()
+	dropped := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "dropped_bucket_seconds", Buckets: []float64{0.1, 1}})
+	renamed := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "old_seconds", Buckets: []float64{0.1, 1}})
+	summary := prometheus.NewSummary(prometheus.SummaryOpts{Name: "rpc_seconds", Objectives: map[float64]float64{0.5: 0.05, 0.9: 0.01}})
+	conflicting := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "conflicting_seconds", Buckets: []float64{1}})
+	counter := prometheus.NewCounter(prometheus.CounterOpts{Name: "counter_total"})
+	gauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "gauge"}, []string{"kind"})
+	reg.MustRegister(dropped, renamed, summary, conflicting, counter, gauge)
+	dropped.Observe(0.5)
+	renamed.Observe(0.5)
+	summary.Observe(0.5)
+	conflicting.Observe(0.5)
+	counter.Add(3)
+	gauge.WithLabelValues("g").Set(5)
+
+	mfs, err := NewRelabelingGatherer(reg).Gather()
+	require.NoError(t, err)
+	byName := map[string]*dto.MetricFamily{}
+	for _, mf := range mfs {
+		byName[mf.GetName()] = mf
+	}
+
+	// a dropped bucket turns the histogram into the untyped samples the scrape ingests
+	assert.Nil(t, byName["dropped_bucket_seconds"])
+	buckets := byName["dropped_bucket_seconds_bucket"]
+	require.NotNil(t, buckets)
+	assert.Equal(t, dto.MetricType_UNTYPED, buckets.GetType())
+	require.Len(t, buckets.Metric, 2)
+	assert.Equal(t, map[string]string{"le": "1.0"}, labelsMap(buckets.Metric[0]))
+	assert.Equal(t, map[string]string{"le": "+Inf"}, labelsMap(buckets.Metric[1]))
+	assert.Equal(t, 1., buckets.Metric[1].GetUntyped().GetValue())
+	assert.Equal(t, dto.MetricType_UNTYPED, byName["dropped_bucket_seconds_count"].GetType())
+	assert.Equal(t, 0.5, byName["dropped_bucket_seconds_sum"].Metric[0].GetUntyped().GetValue())
+
+	// renaming all the series of a histogram in the same way keeps it a histogram
+	h := byName["new_seconds"]
+	require.NotNil(t, h)
+	assert.Equal(t, dto.MetricType_HISTOGRAM, h.GetType())
+	assert.Equal(t, uint64(1), h.Metric[0].GetHistogram().GetSampleCount())
+
+	// rules matching the quantile label apply to the summary quantiles
+	s := byName["rpc_seconds"]
+	require.NotNil(t, s)
+	assert.Equal(t, dto.MetricType_UNTYPED, s.GetType())
+	require.Len(t, s.Metric, 1)
+	assert.Equal(t, map[string]string{"quantile": "0.9"}, labelsMap(s.Metric[0]))
+	assert.NotNil(t, byName["rpc_seconds_sum"])
+	assert.NotNil(t, byName["rpc_seconds_count"])
+
+	// a gauge renamed into a counter family makes it untyped, a histogram is split into samples
+	c := byName["counter_total"]
+	require.NotNil(t, c)
+	assert.Equal(t, dto.MetricType_UNTYPED, c.GetType())
+	require.Len(t, c.Metric, 2)
+	assert.Equal(t, 3., c.Metric[0].GetUntyped().GetValue())
+	assert.Equal(t, 5., c.Metric[1].GetUntyped().GetValue())
+	assert.Equal(t, dto.MetricType_UNTYPED, byName["counter_total_bucket"].GetType())
+	assert.Equal(t, dto.MetricType_UNTYPED, byName["counter_total_count"].GetType())
+}
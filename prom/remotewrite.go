@@ -0,0 +1,35 @@
+package prom
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/prometheus/prometheus/config"
+	"gopkg.in/yaml.v2"
+)
+
+type remoteWriteConfigFile struct {
+	RemoteWriteConfigs []*config.RemoteWriteConfig `yaml:"remote_write"`
+}
+
+// LoadRemoteWriteConfig reads the remote_write section of a YAML file in the Prometheus format.
+// Each destination has its own url, headers, queue_config and write_relabel_configs.
+func LoadRemoteWriteConfig(path string) ([]*config.RemoteWriteConfig, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg remoteWriteConfigFile
+	if err = yaml.UnmarshalStrict(data, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	for i, c := range cfg.RemoteWriteConfigs {
+		if c == nil {
+			return nil, fmt.Errorf("failed to parse %s: empty remote_write config #%d", path, i)
+		}
+	}
+	return cfg.RemoteWriteConfigs, nil
+}
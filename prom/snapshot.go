@@ -0,0 +1,22 @@
+package prom
+
+import (
+	"github.com/coroot/coroot-node-agent/common"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// SnapshotGatherer shares the data read from /proc and /sys between all the collectors within a Gather call.
+type SnapshotGatherer struct {
+	g prometheus.Gatherer
+}
+
+func NewSnapshotGatherer(g prometheus.Gatherer) *SnapshotGatherer {
+	return &SnapshotGatherer{g: g}
+}
+
+func (sg *SnapshotGatherer) Gather() ([]*dto.MetricFamily, error) {
+	snapshot := common.BeginSnapshot()
+	defer snapshot.End()
+	return sg.g.Gather()
+}
@@ -0,0 +1,30 @@
+package prom
+
+import (
+	"time"
+
+	"github.com/prometheus/prometheus/tsdb/agent"
+)
+
+// walTruncateFrequency is how often the agent's storage truncates the WAL.
+// Each truncation also starts a new segment, so the WAL doesn't keep more than this interval of samples beyond maxAge.
+const walTruncateFrequency = 5 * time.Minute
+
+// walOptions configures the agent's storage to drop the samples older than maxAge on truncation,
+// which bounds the size of the WAL when remote write can't keep up (e.g., the endpoint is unreachable).
+// The samples that have been sent are dropped earlier, the WAL of a healthy agent holds only a few minutes of data.
+func walOptions(maxAge time.Duration) *agent.Options {
+	opts := agent.DefaultOptions()
+	if maxAge <= 0 {
+		return opts
+	}
+	opts.TruncateFrequency = walTruncateFrequency
+	if maxAge < opts.TruncateFrequency {
+		opts.TruncateFrequency = maxAge
+	}
+	opts.MaxWALTime = maxAge.Milliseconds()
+	if opts.MinWALTime > opts.MaxWALTime {
+		opts.MinWALTime = opts.MaxWALTime
+	}
+	return opts
+}
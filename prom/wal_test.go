@@ -0,0 +1,69 @@
+package prom
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/storage/remote"
+	"github.com/prometheus/prometheus/tsdb/agent"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWALOptions(t *testing.T) {
+	opts := walOptions(time.Hour)
+	assert.Equal(t, walTruncateFrequency, opts.TruncateFrequency)
+	assert.Equal(t, time.Hour.Milliseconds(), opts.MaxWALTime)
+	assert.Equal(t, agent.DefaultMinWALTime, opts.MinWALTime)
+
+	opts = walOptions(time.Minute)
+	assert.Equal(t, time.Minute, opts.TruncateFrequency)
+	assert.Equal(t, time.Minute.Milliseconds(), opts.MaxWALTime)
+	assert.Equal(t, time.Minute.Milliseconds(), opts.MinWALTime)
+
+	assert.Equal(t, agent.DefaultOptions(), walOptions(0))
+
+	// the agent storage accepts the options as is
+	dir := t.TempDir()
+	logger := log.NewNopLogger()
+	rs := remote.NewStorage(logger, nil, nil, dir, time.Second, nil)
+	defer rs.Close()
+	opts = walOptions(time.Minute)
+	db, err := agent.Open(logger, prometheus.NewRegistry(), rs, dir, opts)
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+	assert.Equal(t, walOptions(time.Minute), opts)
+}
+
+func TestLoadRemoteWriteConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "remote_write.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+remote_write:
+- url: http://prometheus:9090/api/v1/write
+  headers:
+    X-Scope-OrgID: node
+  queue_config:
+    max_shards: 5
+  write_relabel_configs:
+  - source_labels: [__name__]
+    regex: node_.+
+    action: keep
+- url: http://victoria:8428/api/v1/write
+`), 0644))
+	cfgs, err := LoadRemoteWriteConfig(path)
+	require.NoError(t, err)
+	require.Len(t, cfgs, 2)
+	assert.Equal(t, "node", cfgs[0].Headers["X-Scope-OrgID"])
+	assert.Equal(t, 5, cfgs[0].QueueConfig.MaxShards)
+	assert.Len(t, cfgs[0].WriteRelabelConfigs, 1)
+	assert.Equal(t, "victoria:8428", cfgs[1].URL.Host)
+	assert.Greater(t, cfgs[1].QueueConfig.Capacity, 0)
+
+	require.NoError(t, os.WriteFile(path, []byte("remote_write:\n- headers: {}\n"), 0644))
+	_, err = LoadRemoteWriteConfig(path)
+	assert.Error(t, err)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"strings"
+
+	"github.com/coroot/coroot-node-agent/flags"
+	"github.com/coroot/coroot-node-agent/prom"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/exporter-toolkit/web"
+	"k8s.io/klog/v2"
+)
+
+// serve starts the main listener, which is protected according to the web config (TLS, basic auth, client certificates)
+// and the optional bearer token. pprof and debug endpoints are served by a separate listener bound to a loopback address.
+// The health probes can also be served by a separate listener, since the orchestrator can't pass the web config auth.
+func serve(handler http.Handler, debug *http.ServeMux, probes http.Handler) error {
+	if *flags.HealthListenAddress != "" {
+		go func() {
+			klog.Infoln("health probes are listening on:", *flags.HealthListenAddress)
+			klog.Errorln(http.ListenAndServe(*flags.HealthListenAddress, probes))
+		}()
+	}
+	if *flags.DebugListenAddress != "" {
+		if err := checkLoopback(*flags.DebugListenAddress); err != nil {
+			return err
+		}
+		debug.HandleFunc("/debug/pprof/", pprof.Index)
+		debug.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		debug.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		debug.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		debug.HandleFunc("/debug/pprof/trace", pprof.Trace)
+		go func() {
+			klog.Infoln("debug endpoints are listening on:", *flags.DebugListenAddress)
+			klog.Errorln(http.ListenAndServe(*flags.DebugListenAddress, debug))
+		}()
+	}
+
+	if *flags.BearerTokenFile != "" {
+		data, err := os.ReadFile(*flags.BearerTokenFile)
+		if err != nil {
+			return err
+		}
+		token := strings.TrimSpace(string(data))
+		if token == "" {
+			return fmt.Errorf("the bearer token file %s is empty", *flags.BearerTokenFile)
+		}
+		handler = bearerTokenAuth(handler, token)
+	}
+	listenAddresses := []string{*flags.ListenAddress}
+	systemdSocket := false
+	server := &http.Server{Handler: handler}
+	klog.Infoln("listening on:", *flags.ListenAddress)
+	return web.ListenAndServe(server, &web.FlagConfig{
+		WebListenAddresses: &listenAddresses,
+		WebSystemdSocket:   &systemdSocket,
+		WebConfigFile:      flags.WebConfigFile,
+	}, level.NewFilter(prom.Logger{}, level.AllowInfo()))
+}
+
+// bearerTokenAuth requires the token for all the endpoints except the health probes,
+// which are used by the orchestrator and expose no metrics.
+func bearerTokenAuth(h http.Handler, token string) http.Handler {
+	expected := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/healthz" || r.URL.Path == "/readyz" {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+func checkLoopback(address string) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return fmt.Errorf("invalid debug listen address %s: %w", address, err)
+	}
+	if host == "localhost" {
+		return nil
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		return nil
+	}
+	return fmt.Errorf("the debug listen address %s must be a loopback address", address)
+}
@@ -3,11 +3,15 @@ package tracing
 import (
 	"context"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/coroot/coroot-node-agent/common"
 	"github.com/coroot/coroot-node-agent/ebpftracer/l7"
+	"github.com/coroot/coroot-node-agent/fileexport"
 	"github.com/coroot/coroot-node-agent/flags"
+	"github.com/coroot/coroot-node-agent/governor"
+	"github.com/coroot/coroot-node-agent/health"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/codes"
 	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
@@ -16,6 +20,7 @@ import (
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	semconv "go.opentelemetry.io/otel/semconv/v1.18.0"
 	"go.opentelemetry.io/otel/trace"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
 	"inet.af/netaddr"
 	"k8s.io/klog/v2"
 )
@@ -25,93 +30,161 @@ const (
 )
 
 var (
-	tracer func(containerId string) trace.Tracer
+	tracer     func(containerId string) trace.Tracer
+	processors []sdktrace.SpanProcessor
+	lock       sync.RWMutex
 )
 
 func Init(machineId, hostname, version string) {
-	endpointUrl := *flags.TracesEndpoint
-	if endpointUrl == nil {
+	setup(machineId, hostname, version)
+	flags.OnReload(func() {
+		setup(machineId, hostname, version)
+	}, "traces-endpoint", "collector-endpoint", "api-key")
+}
+
+// setup (re)creates the exporters, the previous ones are flushed and shut down.
+func setup(machineId, hostname, version string) {
+	var batchers []sdktrace.SpanProcessor
+	if endpointUrl := flags.Get(flags.TracesEndpoint); endpointUrl != nil {
+		klog.Infoln("OpenTelemetry traces collector endpoint:", endpointUrl.String())
+		path := endpointUrl.Path
+		if path == "" {
+			path = "/"
+		}
+		opts := []otlptracehttp.Option{
+			otlptracehttp.WithEndpoint(endpointUrl.Host),
+			otlptracehttp.WithURLPath(path),
+			otlptracehttp.WithHeaders(common.AuthHeaders()),
+		}
+		if endpointUrl.Scheme != "https" {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		batchers = append(batchers, newBatchSpanProcessor("traces_exporter", otlptracehttp.NewClient(opts...)))
+	} else {
 		klog.Infoln("no OpenTelemetry traces collector endpoint configured")
-		return
 	}
-	klog.Infoln("OpenTelemetry traces collector endpoint:", endpointUrl.String())
-	path := endpointUrl.Path
-	if path == "" {
-		path = "/"
+	if w := fileexport.GetWriter(fileexport.Traces); w != nil {
+		batchers = append(batchers, newBatchSpanProcessor("traces_file_export", fileexport.NewOtlpClient(w)))
 	}
-	opts := []otlptracehttp.Option{
-		otlptracehttp.WithEndpoint(endpointUrl.Host),
-		otlptracehttp.WithURLPath(path),
-		otlptracehttp.WithHeaders(common.AuthHeaders()),
+
+	var t func(containerId string) trace.Tracer
+	if len(batchers) > 0 {
+		t = func(containerId string) trace.Tracer {
+			opts := []sdktrace.TracerProviderOption{
+				sdktrace.WithResource(resource.NewWithAttributes(
+					semconv.SchemaURL,
+					semconv.HostName(hostname),
+					semconv.HostID(machineId),
+					semconv.ServiceName(common.ContainerIdToOtelServiceName(containerId)),
+					semconv.ContainerID(containerId),
+				)),
+			}
+			for _, b := range batchers {
+				opts = append(opts, sdktrace.WithSpanProcessor(b))
+			}
+			return sdktrace.NewTracerProvider(opts...).Tracer("coroot-node-agent", trace.WithInstrumentationVersion(version))
+		}
 	}
-	if endpointUrl.Scheme != "https" {
-		opts = append(opts, otlptracehttp.WithInsecure())
+
+	lock.Lock()
+	prev := processors
+	tracer, processors = t, batchers
+	lock.Unlock()
+	shutdown(context.Background(), prev)
+}
+
+// Shutdown flushes the buffered spans and stops the exporters, the spans recorded afterward are dropped.
+func Shutdown(ctx context.Context) {
+	lock.Lock()
+	prev := processors
+	tracer, processors = nil, nil
+	lock.Unlock()
+	shutdown(ctx, prev)
+}
+
+func shutdown(ctx context.Context, processors []sdktrace.SpanProcessor) {
+	for _, p := range processors {
+		if err := p.Shutdown(ctx); err != nil {
+			klog.Warningln(err)
+		}
 	}
-	client := otlptracehttp.NewClient(opts...)
-	exporter, err := otlptrace.New(context.Background(), client)
+}
+
+func getTracer() func(containerId string) trace.Tracer {
+	lock.RLock()
+	defer lock.RUnlock()
+	return tracer
+}
+
+func newBatchSpanProcessor(name string, client otlptrace.Client) sdktrace.SpanProcessor {
+	exporter, err := otlptrace.New(context.Background(), &healthReportingClient{Client: client, name: name})
 	if err != nil {
 		klog.Exitln(err)
 	}
+	return sdktrace.NewBatchSpanProcessor(exporter)
+}
 
-	batcher := sdktrace.WithBatcher(exporter)
+// healthReportingClient reports the result of each export to the health endpoint.
+type healthReportingClient struct {
+	otlptrace.Client
+	name string
+}
 
-	tracer = func(containerId string) trace.Tracer {
-		provider := sdktrace.NewTracerProvider(
-			batcher,
-			sdktrace.WithResource(resource.NewWithAttributes(
-				semconv.SchemaURL,
-				semconv.HostName(hostname),
-				semconv.HostID(machineId),
-				semconv.ServiceName(common.ContainerIdToOtelServiceName(containerId)),
-				semconv.ContainerID(containerId),
-			)),
-		)
-		return provider.Tracer("coroot-node-agent", trace.WithInstrumentationVersion(version))
-	}
+func (c *healthReportingClient) UploadTraces(ctx context.Context, protoSpans []*tracepb.ResourceSpans) error {
+	err := c.Client.UploadTraces(ctx, protoSpans)
+	health.Report(c.name, err)
+	return err
 }
 
 type Trace struct {
+	tracer      func(containerId string) trace.Tracer
 	containerId string
 	destination netaddr.IPPort
 	commonAttrs []attribute.KeyValue
 }
 
 func NewTrace(containerId string, destination netaddr.IPPort) *Trace {
-	if tracer == nil {
+	tracer := getTracer()
+	if tracer == nil || !governor.SampleTrace() {
 		return nil
 	}
-	return &Trace{containerId: containerId, destination: destination, commonAttrs: []attribute.KeyValue{
+	return &Trace{tracer: tracer, containerId: containerId, destination: destination, commonAttrs: []attribute.KeyValue{
 		semconv.NetPeerName(destination.IP().String()),
 		semconv.NetPeerPort(int(destination.Port())),
 	}}
 }
 
-func (t *Trace) createSpan(name string, duration time.Duration, error bool, attrs ...attribute.KeyValue) {
+// createSpan returns the trace ID of the span so that it can be attached to metrics as an exemplar.
+func (t *Trace) createSpan(name string, duration time.Duration, error bool, attrs ...attribute.KeyValue) string {
 	end := time.Now()
 	start := end.Add(-duration)
-	_, span := tracer(t.containerId).Start(nil, name, trace.WithTimestamp(start), trace.WithSpanKind(trace.SpanKindClient))
+	_, span := t.tracer(t.containerId).Start(nil, name, trace.WithTimestamp(start), trace.WithSpanKind(trace.SpanKindClient))
 	span.SetAttributes(attrs...)
 	span.SetAttributes(t.commonAttrs...)
 	if error {
 		span.SetStatus(codes.Error, "")
 	}
 	span.End(trace.WithTimestamp(end))
+	if sc := span.SpanContext(); sc.IsSampled() {
+		return sc.TraceID().String()
+	}
+	return ""
 }
 
-func (t *Trace) HttpRequest(method, path string, status l7.Status, duration time.Duration) {
+func (t *Trace) HttpRequest(method, path string, status l7.Status, duration time.Duration) string {
 	if t == nil || method == "" {
-		return
+		return ""
 	}
-	t.createSpan(method, duration, status >= 400,
+	return t.createSpan(method, duration, status >= 400,
 		semconv.HTTPURL(fmt.Sprintf("http://%s%s", t.destination.String(), path)),
 		semconv.HTTPMethod(method),
 		semconv.HTTPStatusCode(int(status)),
 	)
 }
 
-func (t *Trace) Http2Request(method, path, scheme string, status l7.Status, duration time.Duration) {
+func (t *Trace) Http2Request(method, path, scheme string, status l7.Status, duration time.Duration) string {
 	if t == nil {
-		return
+		return ""
 	}
 	if method == "" {
 		method = "unknown"
@@ -122,46 +195,46 @@ func (t *Trace) Http2Request(method, path, scheme string, status l7.Status, dura
 	if scheme == "" {
 		scheme = "unknown"
 	}
-	t.createSpan(method, duration, status > 400,
+	return t.createSpan(method, duration, status > 400,
 		semconv.HTTPURL(fmt.Sprintf("%s://%s%s", scheme, t.destination.String(), path)),
 		semconv.HTTPMethod(method),
 		semconv.HTTPStatusCode(int(status)),
 	)
 }
 
-func (t *Trace) PostgresQuery(query string, error bool, duration time.Duration) {
+func (t *Trace) PostgresQuery(query string, error bool, duration time.Duration) string {
 	if t == nil || query == "" {
-		return
+		return ""
 	}
-	t.createSpan("query", duration, error,
+	return t.createSpan("query", duration, error,
 		semconv.DBSystemPostgreSQL,
 		semconv.DBStatement(query),
 	)
 }
 
-func (t *Trace) MysqlQuery(query string, error bool, duration time.Duration) {
+func (t *Trace) MysqlQuery(query string, error bool, duration time.Duration) string {
 	if t == nil || query == "" {
-		return
+		return ""
 	}
-	t.createSpan("query", duration, error,
+	return t.createSpan("query", duration, error,
 		semconv.DBSystemMySQL,
 		semconv.DBStatement(query),
 	)
 }
 
-func (t *Trace) MongoQuery(query string, error bool, duration time.Duration) {
+func (t *Trace) MongoQuery(query string, error bool, duration time.Duration) string {
 	if t == nil || query == "" {
-		return
+		return ""
 	}
-	t.createSpan("query", duration, error,
+	return t.createSpan("query", duration, error,
 		semconv.DBSystemMongoDB,
 		semconv.DBStatement(query),
 	)
 }
 
-func (t *Trace) MemcachedQuery(cmd string, items []string, error bool, duration time.Duration) {
+func (t *Trace) MemcachedQuery(cmd string, items []string, error bool, duration time.Duration) string {
 	if t == nil || cmd == "" {
-		return
+		return ""
 	}
 	attrs := []attribute.KeyValue{
 		semconv.DBSystemMemcached,
@@ -172,18 +245,18 @@ func (t *Trace) MemcachedQuery(cmd string, items []string, error bool, duration
 	} else if len(items) > 1 {
 		attrs = append(attrs, MemcacheDBItemKeyName.StringSlice(items))
 	}
-	t.createSpan(cmd, duration, error, attrs...)
+	return t.createSpan(cmd, duration, error, attrs...)
 }
 
-func (t *Trace) RedisQuery(cmd, args string, error bool, duration time.Duration) {
+func (t *Trace) RedisQuery(cmd, args string, error bool, duration time.Duration) string {
 	if t == nil || cmd == "" {
-		return
+		return ""
 	}
 	statement := cmd
 	if args != "" {
 		statement += " " + args
 	}
-	t.createSpan(cmd, duration, error,
+	return t.createSpan(cmd, duration, error,
 		semconv.DBSystemRedis,
 		semconv.DBOperation(cmd),
 		semconv.DBStatement(statement),